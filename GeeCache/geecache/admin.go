@@ -0,0 +1,113 @@
+// HTTPPool 的管理接口，用于在运行时修改节点和 Group，不需要重启进程
+//
+//	GET    /_geecache_admin/peers                            列出所有节点
+//	POST   /_geecache_admin/peers?peer=<addr>&...            增加节点
+//	DELETE /_geecache_admin/peers?peer=<addr>&...            删除节点
+//	GET    /_geecache_admin/groups                           列出所有 Group
+//	PUT    /_geecache_admin/groups?group=<name>&bytes=<n>    修改 Group 的内存上限
+//	DELETE /_geecache_admin/groups?group=<name>              销毁 Group
+
+package geecache
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func (p *HTTPPool) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	p.Log("%s %s", r.Method, r.URL.Path)
+	resource := strings.Trim(r.URL.Path[len(p.adminPath):], "/")
+	switch resource {
+	case "peers":
+		p.servePeers(w, r)
+	case "groups":
+		p.serveGroups(w, r)
+	default:
+		http.Error(w, "unknown admin resource: "+resource, http.StatusNotFound)
+	}
+}
+
+func (p *HTTPPool) servePeers(w http.ResponseWriter, r *http.Request) {
+	peers := r.URL.Query()["peer"]
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if len(peers) == 0 {
+			http.Error(w, "peer is required", http.StatusBadRequest)
+			return
+		}
+		p.AddPeers(peers...)
+	case http.MethodDelete:
+		if len(peers) == 0 {
+			http.Error(w, "peer is required", http.StatusBadRequest)
+			return
+		}
+		p.RemovePeers(peers...)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	//返回修改之后的节点列表
+	writeJSON(w, p.Peers())
+}
+
+// 管理接口返回的 Group 信息，Bytes 和 Items 是 mainCache 和 hotCache 的总和
+type groupInfo struct {
+	Name       string `json:"name"`
+	CacheBytes int64  `json:"cache_bytes"`
+	Bytes      int64  `json:"bytes"`
+	Items      int64  `json:"items"`
+}
+
+func (p *HTTPPool) serveGroups(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("group")
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		group := p.cache.GetGroup(name)
+		if group == nil {
+			http.Error(w, "no such group: "+name, http.StatusNotFound)
+			return
+		}
+		bytes, err := strconv.ParseInt(r.URL.Query().Get("bytes"), 10, 64)
+		if err != nil || bytes < 0 {
+			http.Error(w, "bytes must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		if err = group.Resize(bytes); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+	case http.MethodDelete:
+		if !p.cache.DestroyGroup(name) {
+			http.Error(w, "no such group: "+name, http.StatusNotFound)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	//返回修改之后的 Group 列表
+	list := make([]groupInfo, 0)
+	for _, g := range p.cache.sortedGroups() {
+		main, hot := g.CacheStats(MainCache), g.CacheStats(HotCache)
+		list = append(list, groupInfo{
+			Name:       g.name,
+			CacheBytes: g.CacheBytes(),
+			Bytes:      main.Bytes + hot.Bytes,
+			Items:      main.Items + hot.Items,
+		})
+	}
+	writeJSON(w, list)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,259 @@
+// ARC 淘汰策略（Megiddo & Modha, Adaptive Replacement Cache）。
+// T1 保存只访问过一次的记录，T2 保存访问过多次的记录，
+// B1、B2 分别是从 T1、T2 淘汰出去的幽灵 key。
+// 幽灵 key 再次出现时调整 T1 的目标大小 p，在“最近”和“频繁”之间自适应。
+// 这里的容量按字节计算，p 也是字节数。
+
+package arc
+
+import (
+	"container/list"
+	"geecache/policy"
+	"time"
+)
+
+type Cache struct {
+	maxBytes int64
+	//T1 的目标大小
+	p int64
+
+	t1, t2           *list.List
+	t1Bytes, t2Bytes int64
+	b1, b2           *list.List
+	b1Bytes, b2Bytes int64
+
+	//T1、T2 中的记录
+	cache map[string]*list.Element
+	//B1、B2 中的幽灵 key
+	ghosts map[string]*list.Element
+	//某条记录被移除时的回调函数
+	OnEvicted policy.EvictFunc
+}
+
+type entry struct {
+	key    string
+	value  policy.Value
+	expire time.Time
+	//是否在 T2 中
+	inT2 bool
+}
+
+type ghostEntry struct {
+	key  string
+	size int64
+	//是否在 B2 中
+	inB2 bool
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.key)) + int64(e.value.Len())
+}
+
+func New(maxBytes int64, onEvicted policy.EvictFunc) *Cache {
+	return &Cache{
+		maxBytes:  maxBytes,
+		t1:        list.New(),
+		t2:        list.New(),
+		b1:        list.New(),
+		b2:        list.New(),
+		cache:     make(map[string]*list.Element),
+		ghosts:    make(map[string]*list.Element),
+		OnEvicted: onEvicted,
+	}
+}
+
+// 满足 policy.Factory
+func NewPolicy(maxBytes int64, onEvicted policy.EvictFunc) policy.Policy {
+	return New(maxBytes, onEvicted)
+}
+
+var _ policy.Policy = (*Cache)(nil)
+var _ policy.Ranger = (*Cache)(nil)
+
+func (c *Cache) Len() int {
+	return len(c.cache)
+}
+
+func (c *Cache) Bytes() int64 {
+	return c.t1Bytes + c.t2Bytes
+}
+
+func (c *Cache) Get(key string) (value policy.Value, ok bool) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	e := ele.Value.(*entry)
+	if policy.Expired(e.expire, time.Now()) {
+		c.removeElement(ele, policy.EvictExpired)
+		return nil, false
+	}
+	c.promote(ele)
+	return e.value, true
+}
+
+func (c *Cache) AddWithExpire(key string, value policy.Value, expire time.Time) {
+	if ele, ok := c.cache[key]; ok {
+		e := ele.Value.(*entry)
+		delta := int64(value.Len()) - int64(e.value.Len())
+		if e.inT2 {
+			c.t2Bytes += delta
+		} else {
+			c.t1Bytes += delta
+		}
+		e.value = value
+		e.expire = expire
+		c.promote(ele)
+		c.evict(false)
+		return
+	}
+
+	e := &entry{key: key, value: value, expire: expire}
+	inB2 := false
+	if gele, ok := c.ghosts[key]; ok {
+		g := gele.Value.(*ghostEntry)
+		inB2 = g.inB2
+		if g.inB2 {
+			//B2 命中说明 T2 太小，减小 p
+			c.p -= e.size() * max64(1, c.b1Bytes/max64(c.b2Bytes, 1))
+			if c.p < 0 {
+				c.p = 0
+			}
+		} else {
+			//B1 命中说明 T1 太小，增大 p
+			c.p += e.size() * max64(1, c.b2Bytes/max64(c.b1Bytes, 1))
+			if c.maxBytes != 0 && c.p > c.maxBytes {
+				c.p = c.maxBytes
+			}
+		}
+		c.removeGhost(gele)
+		e.inT2 = true
+		c.cache[key] = c.t2.PushFront(e)
+		c.t2Bytes += e.size()
+	} else {
+		c.cache[key] = c.t1.PushFront(e)
+		c.t1Bytes += e.size()
+	}
+	c.evict(inB2)
+}
+
+func (c *Cache) RemoveKey(key string) bool {
+	ele, ok := c.cache[key]
+	if ok {
+		c.removeElement(ele, policy.EvictRemoved)
+	}
+	return ok
+}
+
+func (c *Cache) RemoveExpired(now time.Time) int {
+	n := 0
+	for _, ele := range c.cache {
+		if policy.Expired(ele.Value.(*entry).expire, now) {
+			c.removeElement(ele, policy.EvictExpired)
+			n++
+		}
+	}
+	return n
+}
+
+// 命中的记录移动到 T2 的头部
+func (c *Cache) promote(ele *list.Element) {
+	e := ele.Value.(*entry)
+	if e.inT2 {
+		c.t2.MoveToFront(ele)
+		return
+	}
+	c.t1.Remove(ele)
+	c.t1Bytes -= e.size()
+	e.inT2 = true
+	c.cache[e.key] = c.t2.PushFront(e)
+	c.t2Bytes += e.size()
+}
+
+// 超出容量时淘汰记录，并限制幽灵 key 的数量
+func (c *Cache) evict(inB2 bool) {
+	if c.maxBytes == 0 {
+		return
+	}
+	for c.t1Bytes+c.t2Bytes > c.maxBytes {
+		c.replace(inB2)
+	}
+	for c.t1Bytes+c.b1Bytes > c.maxBytes && c.b1.Len() > 0 {
+		c.removeGhost(c.b1.Back())
+	}
+	for c.t1Bytes+c.t2Bytes+c.b1Bytes+c.b2Bytes > 2*c.maxBytes && c.b2.Len() > 0 {
+		c.removeGhost(c.b2.Back())
+	}
+}
+
+// T1 超过目标大小 p 时从 T1 淘汰，否则从 T2 淘汰，被淘汰的 key 进入对应的幽灵队列
+func (c *Cache) replace(inB2 bool) {
+	var ele *list.Element
+	if c.t1.Len() > 0 && (c.t1Bytes > c.p || (inB2 && c.t1Bytes == c.p) || c.t2.Len() == 0) {
+		ele = c.t1.Back()
+	} else {
+		ele = c.t2.Back()
+	}
+	e := ele.Value.(*entry)
+	c.removeElement(ele, policy.EvictCapacity)
+	g := &ghostEntry{key: e.key, size: e.size(), inB2: e.inT2}
+	if g.inB2 {
+		c.ghosts[g.key] = c.b2.PushFront(g)
+		c.b2Bytes += g.size
+	} else {
+		c.ghosts[g.key] = c.b1.PushFront(g)
+		c.b1Bytes += g.size
+	}
+}
+
+func (c *Cache) removeGhost(ele *list.Element) {
+	g := ele.Value.(*ghostEntry)
+	if g.inB2 {
+		c.b2.Remove(ele)
+		c.b2Bytes -= g.size
+	} else {
+		c.b1.Remove(ele)
+		c.b1Bytes -= g.size
+	}
+	delete(c.ghosts, g.key)
+}
+
+func (c *Cache) removeElement(ele *list.Element, reason policy.EvictReason) {
+	e := ele.Value.(*entry)
+	if e.inT2 {
+		c.t2.Remove(ele)
+		c.t2Bytes -= e.size()
+	} else {
+		c.t1.Remove(ele)
+		c.t1Bytes -= e.size()
+	}
+	delete(c.cache, e.key)
+	if c.OnEvicted != nil {
+		c.OnEvicted(e.key, e.value, reason)
+	}
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// 先遍历 T1，再遍历 T2，各自从最早的记录开始
+func (c *Cache) Range(fn func(key string, value policy.Value, expire time.Time) bool) {
+	if rangeList(c.t1, fn) {
+		rangeList(c.t2, fn)
+	}
+}
+
+// 从 back 到 front 遍历链表中的 entry
+func rangeList(l *list.List, fn func(key string, value policy.Value, expire time.Time) bool) bool {
+	for ele := l.Back(); ele != nil; ele = ele.Prev() {
+		e := ele.Value.(*entry)
+		if !fn(e.key, e.value, e.expire) {
+			return false
+		}
+	}
+	return true
+}
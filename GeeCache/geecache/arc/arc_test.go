@@ -0,0 +1,83 @@
+package arc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (s String) Len() int {
+	return len(s)
+}
+
+func TestGet(t *testing.T) {
+	c := New(int64(0), nil)
+	c.AddWithExpire("key1", String("1234"), time.Time{})
+	if v, ok := c.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+	if _, ok := c.Get("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+}
+
+// 访问过两次的记录进入 T2，一次性的扫描只会淘汰 T1 中的记录
+func TestScanResistant(t *testing.T) {
+	c := New(int64(200), nil)
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("hot%d", i)
+		c.AddWithExpire(key, String("0123456789"), time.Time{})
+		c.Get(key)
+	}
+	for i := 0; i < 1000; i++ {
+		c.AddWithExpire(fmt.Sprintf("scan%04d", i), String("0123456789"), time.Time{})
+	}
+	for i := 0; i < 5; i++ {
+		if _, ok := c.Get(fmt.Sprintf("hot%d", i)); !ok {
+			t.Fatalf("hot%d was flushed by scan", i)
+		}
+	}
+	if c.t1Bytes+c.t2Bytes > 200 {
+		t.Fatalf("cache uses %d bytes, more than 200", c.t1Bytes+c.t2Bytes)
+	}
+	if c.t1Bytes+c.t2Bytes+c.b1Bytes+c.b2Bytes > 400 {
+		t.Fatalf("ghost lists grow beyond twice the capacity")
+	}
+}
+
+// B1 命中时增大 T1 的目标大小
+func TestAdapt(t *testing.T) {
+	c := New(int64(100), nil)
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("k%d", i)
+		c.AddWithExpire(key, String("012345678"), time.Time{})
+		c.Get(key)
+	}
+	for i := 0; i < 6; i++ {
+		c.AddWithExpire(fmt.Sprintf("s%d", i), String("012345678"), time.Time{})
+	}
+	if _, ok := c.ghosts["s0"]; !ok {
+		t.Fatalf("s0 should be remembered in B1")
+	}
+	c.AddWithExpire("s0", String("012345678"), time.Time{})
+	if c.p == 0 {
+		t.Fatalf("p should grow after a B1 hit")
+	}
+	if _, ok := c.Get("s0"); !ok {
+		t.Fatalf("s0 should be cached again")
+	}
+}
+
+func TestExpire(t *testing.T) {
+	c := New(int64(0), nil)
+	c.AddWithExpire("k1", String("v1"), time.Now().Add(-time.Second))
+	c.AddWithExpire("k2", String("v2"), time.Now().Add(time.Hour))
+	if _, ok := c.Get("k1"); ok {
+		t.Fatalf("expired k1 should miss")
+	}
+	if n := c.RemoveExpired(time.Now().Add(2 * time.Hour)); n != 1 || c.Len() != 0 {
+		t.Fatalf("RemoveExpired should remove k2, removed %d", n)
+	}
+}
@@ -0,0 +1,149 @@
+// 节点之间的认证，两种方式可以同时使用
+//
+// mTLS：WithMutualTLS 设置本节点的证书和信任的 CA。访问其他节点时出示自己的证书，
+// 服务端使用 HTTPPool.TLSConfig 启动 TLS，没有出示可信证书的请求返回 403。
+//
+// HMAC：没有 PKI 的环境可以用 WithSharedSecret 设置所有节点共享的密钥，每个请求带上两个请求头：
+//
+//	X-Geecache-Timestamp  请求时间，Unix 秒
+//	X-Geecache-Signature  hex(HMAC-SHA256(secret, method + "\n" + requestURI + "\n" + timestamp + "\n" + hex(SHA256(body))))
+//
+// 缺少签名、签名不正确或者时间戳和服务端相差超过 maxClockSkew 的请求返回 401。
+// 时间戳限制了截获的请求能被重放的时间，但是不能完全防止重放，需要时应该同时使用 TLS。
+//
+// 健康检查和 metrics 不需要认证。
+
+package geecache
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	timestampHeader = "X-Geecache-Timestamp"
+	signatureHeader = "X-Geecache-Signature"
+	//401 响应中的认证方式
+	authScheme = "GeeCache-HMAC"
+	//服务端和请求方的时钟允许相差的时间
+	maxClockSkew = 5 * time.Minute
+)
+
+// 节点之间通过 mTLS 通信：cert 是本节点的证书，roots 用来校验其他节点的证书
+// 节点地址需要使用 https://，服务端需要使用 TLSConfig 返回的配置启动
+func WithMutualTLS(cert tls.Certificate, roots *x509.CertPool) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientCAs:    roots,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			MinVersion:   tls.VersionTLS12,
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      roots,
+			MinVersion:   tls.VersionTLS12,
+		}
+		p.client.Transport = transport
+	}
+}
+
+// 使用共享密钥给节点之间的请求签名，所有节点必须使用同一个密钥
+func WithSharedSecret(secret []byte) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.secret = append([]byte(nil), secret...)
+	}
+}
+
+// 服务端使用的 TLS 配置，要求对方出示证书，没有设置 WithMutualTLS 时返回 nil
+//
+//	srv := &http.Server{Addr: addr, Handler: pool, TLSConfig: pool.TLSConfig()}
+//	srv.ListenAndServeTLS("", "")
+func (p *HTTPPool) TLSConfig() *tls.Config {
+	if p.tlsConfig == nil {
+		return nil
+	}
+	return p.tlsConfig.Clone()
+}
+
+// 检查请求是否来自其他节点，失败时写入响应并返回 false
+func (p *HTTPPool) authenticate(w http.ResponseWriter, r *http.Request) bool {
+	if p.tlsConfig != nil && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		http.Error(w, "client certificate required", http.StatusForbidden)
+		return false
+	}
+	if p.secret == nil {
+		return true
+	}
+	if err := p.verify(r); err != nil {
+		if status := bodyStatus(err); status == http.StatusRequestEntityTooLarge {
+			http.Error(w, err.Error(), status)
+			return false
+		}
+		w.Header().Set("WWW-Authenticate", authScheme)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// 校验签名，body 被读出来之后替换成同样内容的 Reader，后面的处理不受影响
+// body 在 ServeHTTP 中已经用 http.MaxBytesReader 限制了大小，超过上限时返回 *http.MaxBytesError
+func (p *HTTPPool) verify(r *http.Request) error {
+	timestamp := r.Header.Get(timestampHeader)
+	signature, err := hex.DecodeString(r.Header.Get(signatureHeader))
+	if timestamp == "" || err != nil || len(signature) == 0 {
+		return errors.New("missing or malformed signature")
+	}
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("malformed timestamp")
+	}
+	if skew := time.Since(time.Unix(sec, 0)); skew > maxClockSkew || skew < -maxClockSkew {
+		return errors.New("timestamp out of range")
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if !hmac.Equal(signature, sign(p.secret, r.Method, r.URL.RequestURI(), timestamp, body)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// 创建访问远程节点的请求，设置了密钥时签名
+func (h *httpGetter) newRequest(ctx context.Context, method, u string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if h.overflow {
+		req.Header.Set(overflowHeader, "1")
+	}
+	if h.secret != nil {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(timestampHeader, timestamp)
+		req.Header.Set(signatureHeader, hex.EncodeToString(sign(h.secret, method, req.URL.RequestURI(), timestamp, body)))
+	}
+	return req, nil
+}
+
+func sign(secret []byte, method, uri, timestamp string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, method+"\n"+uri+"\n"+timestamp+"\n"+hex.EncodeToString(sum[:]))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,347 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	pb "geecache/geecachepb"
+	"geecache/singleflight"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// 能够一次加载多个 key 的 Getter
+// GetMany 回源时，本节点负责的 key 会合并成一次调用，返回的 map 中缺少的 key 视为不存在
+type BatchGetter interface {
+	GetMany(ctx context.Context, keys []string) (map[string][]byte, error)
+}
+
+// 函数类型BatchGetterFunc，同时实现了 Getter 和 BatchGetter
+type BatchGetterFunc func(ctx context.Context, keys []string) (map[string][]byte, error)
+
+func (f BatchGetterFunc) Get(key string) ([]byte, error) {
+	values, err := f(context.Background(), []string{key})
+	if err != nil {
+		return nil, err
+	}
+	value, ok := values[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+func (f BatchGetterFunc) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	return f(ctx, keys)
+}
+
+// 一次获取多个 key，返回 key 对应的缓存值
+// 缓存未命中的 key 按所属节点分组，每个远程节点只发送一次批量请求，
+// 本节点负责的 key 在 Getter 实现了 BatchGetter 时合并成一次回源。
+// 不存在的 key 不会出现在结果中；其他错误不影响已经获取到的值，返回遇到的第一个错误
+func (g *Group) GetMany(ctx context.Context, keys []string) (map[string]ByteView, error) {
+	values, errs := g.getMany(ctx, keys)
+	for key, v := range values {
+		v, err := g.decode(v)
+		if err != nil {
+			delete(values, key)
+			errs[key] = err
+			continue
+		}
+		values[key] = v
+	}
+	for _, key := range keys {
+		if err := errs[key]; err != nil && !errors.Is(err, ErrNotFound) {
+			return values, err
+		}
+	}
+	return values, nil
+}
+
+// 返回每个 key 的值或者错误，用于服务端把结果原样返回给请求方
+// 返回的值可能是压缩后的
+func (g *Group) getMany(ctx context.Context, keys []string) (map[string]ByteView, map[string]error) {
+	values := make(map[string]ByteView, len(keys))
+	errs := make(map[string]error)
+	if g.closed.Load() {
+		for _, key := range keys {
+			errs[key] = ErrGroupClosed
+		}
+		return values, errs
+	}
+	var misses []string
+	for _, key := range keys {
+		if _, ok := values[key]; ok {
+			continue
+		}
+		if _, ok := errs[key]; ok {
+			continue
+		}
+		if key == "" {
+			errs[key] = ErrEmptyKey
+			continue
+		}
+		g.stats.gets.Add(1)
+		if v, ok := g.lookupCache(key); ok {
+			g.stats.hits.Add(1)
+			values[key] = v
+			continue
+		}
+		if g.negativeTTL > 0 {
+			if _, ok := g.negCache.get(key); ok {
+				g.stats.negativeHits.Add(1)
+				errs[key] = ErrNotFound
+				continue
+			}
+		}
+		if v, ok := g.getFromDisk(key); ok {
+			values[key] = v
+			continue
+		}
+		g.stats.misses.Add(1)
+		errs[key] = nil
+		misses = append(misses, key)
+	}
+	if len(misses) == 0 {
+		return values, errs
+	}
+	//和 load 一样，合并后的回源使用 loadContext，
+	//发起批量回源的调用者取消时，等待这些 key 的其他调用者不受影响
+	results := g.loader.DoMany(ctx, misses, func(keys []string) map[string]singleflight.Result {
+		ctx, cancel := g.loadContext(ctx)
+		defer cancel()
+		return g.loadMany(ctx, keys)
+	})
+	for key, r := range results {
+		if r.Err != nil {
+			errs[key] = r.Err
+			continue
+		}
+		delete(errs, key)
+		values[key] = r.Val.(ByteView)
+	}
+	return values, errs
+}
+
+// 在 mainCache 和 hotCache 中查找
+func (g *Group) lookupCache(key string) (ByteView, bool) {
+	if v, ok := g.mainCache.get(key); ok {
+		return v, true
+	}
+	if g.hotRatio > 0 {
+		return g.hotCache.get(key)
+	}
+	return ByteView{}, false
+}
+
+// 批量加载缓存未命中的 key，远程节点失败的 key 回退到本地
+func (g *Group) loadMany(ctx context.Context, keys []string) map[string]singleflight.Result {
+	results := make(map[string]singleflight.Result, len(keys))
+	var local []string
+	if peers := g.loadPeers(ctx); peers != nil {
+		var mutex sync.Mutex
+		var wg sync.WaitGroup
+		for peer, batch := range g.partition(peers, keys, &local) {
+			wg.Add(1)
+			go func(peer PeerGetter, batch []string) {
+				defer wg.Done()
+				values, errs := g.getManyFromPeer(ctx, peer, batch)
+				mutex.Lock()
+				defer mutex.Unlock()
+				for _, key := range batch {
+					err, failed := errs[key]
+					switch {
+					case !failed:
+						g.stats.peerLoads.Add(1)
+						if g.hotRatio > 0 && rand.Float64() < g.hotSampleRate {
+							g.hotCache.add(key, values[key], values[key].e)
+						}
+						results[key] = singleflight.Result{Val: values[key]}
+					case errors.Is(err, ErrNotFound):
+						g.rememberNotFound(key)
+						results[key] = singleflight.Result{Err: err}
+					case errors.Is(err, ErrPeerUnavailable):
+						//和 load 一样，远程节点不可用时由本地回源
+						local = append(local, key)
+					default:
+						results[key] = singleflight.Result{Err: err}
+					}
+				}
+			}(peer, batch)
+		}
+		wg.Wait()
+	} else {
+		local = keys
+	}
+	if len(local) == 0 {
+		return results
+	}
+	//调用者已经放弃，不再回源
+	if err := ctx.Err(); err != nil {
+		for _, key := range local {
+			results[key] = singleflight.Result{Err: err}
+		}
+		return results
+	}
+	for key, r := range g.getManyLocally(ctx, local) {
+		results[key] = r
+	}
+	return results
+}
+
+// 用 peers 按所属节点把 key 分组，本节点负责的 key 追加到 local
+func (g *Group) partition(peers PeerPicker, keys []string, local *[]string) map[PeerGetter][]string {
+	batches := make(map[PeerGetter][]string)
+	//PickPeer 每次可能返回新的 PeerGetter，按节点地址合并
+	byAddr := make(map[string]PeerGetter)
+	for _, key := range keys {
+		peer, ok := peers.PickPeer(key)
+		if !ok {
+			*local = append(*local, key)
+			continue
+		}
+		if a, ok := peer.(interface{ addr() string }); ok {
+			if first, ok := byAddr[a.addr()]; ok {
+				peer = first
+			} else {
+				byAddr[a.addr()] = peer
+			}
+		}
+		batches[peer] = append(batches[peer], key)
+	}
+	return batches
+}
+
+// 从远程节点批量获取，不支持批量请求的节点逐个获取
+// 整个请求失败时所有 key 都返回同一个错误
+func (g *Group) getManyFromPeer(ctx context.Context, peer PeerGetter, keys []string) (map[string]ByteView, map[string]error) {
+	values := make(map[string]ByteView, len(keys))
+	errs := make(map[string]error)
+	bp, ok := peer.(BatchPeerGetter)
+	if !ok {
+		for _, key := range keys {
+			value, err := g.getFromPeer(ctx, peer, key)
+			if err != nil {
+				errs[key] = err
+				continue
+			}
+			values[key] = value
+		}
+		return values, errs
+	}
+	res := &pb.BatchResponse{}
+	if err := bp.GetMany(ctx, &pb.BatchRequest{Group: g.name, Keys: keys}, res); err != nil {
+		g.stats.peerErrors.Add(1)
+		log.Println("[GeeCache] Failed to get many from peer", err)
+		for _, key := range keys {
+			errs[key] = err
+		}
+		return values, errs
+	}
+	values, errs = g.fromBatchResponse(res)
+	for _, key := range keys {
+		if _, ok := values[key]; ok {
+			continue
+		}
+		if _, ok := errs[key]; !ok {
+			errs[key] = &peerError{err: errors.New("missing from batch response: " + key)}
+		}
+	}
+	return values, errs
+}
+
+// 本地回源，Getter 实现了 BatchGetter 时只调用一次
+func (g *Group) getManyLocally(ctx context.Context, keys []string) map[string]singleflight.Result {
+	results := make(map[string]singleflight.Result, len(keys))
+	getter, ok := g.getter.(BatchGetter)
+	if !ok {
+		for _, key := range keys {
+			value, err := g.getLocally(ctx, key)
+			results[key] = singleflight.Result{Val: value, Err: err}
+		}
+		return results
+	}
+	values, err := getter.GetMany(ctx, keys)
+	if err != nil {
+		g.stats.localLoadErrs.Add(int64(len(keys)))
+		err = &loaderError{err: err}
+		for _, key := range keys {
+			results[key] = singleflight.Result{Err: err}
+		}
+		return results
+	}
+	var expire time.Time
+	if g.ttl > 0 {
+		expire = time.Now().Add(g.ttl)
+	}
+	for _, key := range keys {
+		b, ok := values[key]
+		if !ok {
+			g.stats.localLoadErrs.Add(1)
+			g.rememberNotFound(key)
+			results[key] = singleflight.Result{Err: ErrNotFound}
+			continue
+		}
+		g.stats.localLoads.Add(1)
+		value := ByteView{b: cloneBytes(b)}
+		if err := g.populateCache(key, value, expire); err != nil {
+			log.Printf("[GeeCache] failed to populate %s: %v", key, err)
+		}
+		results[key] = singleflight.Result{Val: value}
+	}
+	return results
+}
+
+// 服务端把 getMany 的结果编码成 BatchResponse
+func (g *Group) toBatchResponse(keys []string, values map[string]ByteView, errs map[string]error) *pb.BatchResponse {
+	res := &pb.BatchResponse{Entries: make([]*pb.BatchEntry, 0, len(keys))}
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		entry := &pb.BatchEntry{Key: key}
+		if v, ok := values[key]; ok {
+			v = g.encode(v)
+			entry.Value = v.ByteSlice()
+			entry.Encoding = g.wireEncoding(v)
+			if !v.Expire().IsZero() {
+				entry.Expire = v.Expire().UnixNano()
+			}
+		} else if err := errs[key]; errors.Is(err, ErrNotFound) {
+			entry.NotFound = true
+		} else if err != nil {
+			entry.Error = err.Error()
+		}
+		res.Entries = append(res.Entries, entry)
+	}
+	return res
+}
+
+// 请求方把 BatchResponse 还原成值和错误，压缩过的值在这里解压
+func (g *Group) fromBatchResponse(res *pb.BatchResponse) (map[string]ByteView, map[string]error) {
+	values := make(map[string]ByteView, len(res.GetEntries()))
+	errs := make(map[string]error)
+	for _, entry := range res.GetEntries() {
+		switch {
+		case entry.GetNotFound():
+			errs[entry.GetKey()] = ErrNotFound
+		case entry.GetError() != "":
+			errs[entry.GetKey()] = &loaderError{err: errors.New(entry.GetError())}
+		default:
+			b, err := decodeWire(entry.GetEncoding(), entry.GetValue(), g.maxValueSize)
+			if err != nil {
+				errs[entry.GetKey()] = err
+				continue
+			}
+			var expire time.Time
+			if entry.GetExpire() != 0 {
+				expire = time.Unix(0, entry.GetExpire())
+			}
+			values[entry.GetKey()] = ByteView{b: b, e: expire}
+		}
+	}
+	return values, errs
+}
@@ -0,0 +1,84 @@
+package geecache
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	//连续失败多少次之后熔断
+	defaultFailureThreshold = 3
+	//熔断之后多久开始探测节点是否恢复
+	defaultBreakerCooldown = 5 * time.Second
+)
+
+// 每个远程节点一个熔断器
+// 连续失败 threshold 次之后熔断，熔断期间不再向该节点发送请求，
+// 冷却时间过后在后台访问节点的健康检查接口，成功则恢复，失败则继续熔断。
+type breaker struct {
+	mutex     sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	//连续失败的次数
+	failures int
+	//熔断到什么时候为止，之后允许探测
+	openUntil time.Time
+	//是否有正在进行的探测
+	probing bool
+	//健康检查
+	probe func() error
+}
+
+func newBreaker(threshold int, cooldown time.Duration, probe func() error) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, probe: probe}
+}
+
+// 是否允许向节点发送请求，没有熔断器（threshold <= 0）时总是允许
+func (b *breaker) allow() bool {
+	if b == nil || b.threshold <= 0 {
+		return true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if !b.probing && !time.Now().Before(b.openUntil) {
+		b.probing = true
+		go b.runProbe()
+	}
+	return false
+}
+
+func (b *breaker) runProbe() {
+	err := b.probe()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		return
+	}
+	b.openUntil = time.Now().Add(b.cooldown)
+}
+
+// 记录一次请求的结果
+// 只有节点不可用才算失败，key 不存在或者远程回源失败说明节点本身是正常的
+func (b *breaker) record(err error) {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !errors.Is(err, ErrPeerUnavailable) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures == b.threshold {
+		log.Println("[GeeCache] circuit open for peer:", err)
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
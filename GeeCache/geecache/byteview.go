@@ -3,10 +3,21 @@
 
 package geecache
 
+import "time"
+
 type ByteView struct {
 	// b 将会存储真实的缓存值,
 	//选择 byte 类型是为了能够支持任意的数据类型的存储
 	b []byte
+	//过期时间，零值表示永不过期，从远程节点获取的值也会带上它
+	e time.Time
+	//b 是否是压缩后的数据，只有 mainCache 中的值会被压缩，返回给调用者之前解压
+	z bool
+}
+
+// 返回过期时间，零值表示永不过期
+func (tmp ByteView) Expire() time.Time {
+	return tmp.e
 }
 
 func (tmp ByteView) Len() int {
@@ -2,40 +2,250 @@ package geecache
 
 import (
 	"geecache/lru"
+	"geecache/policy"
+	"hash/fnv"
 	"sync"
+	"time"
 )
 
+// 按照 key 的哈希值分成多个分片，每个分片单独加锁，
+// 避免所有请求都竞争同一把锁（淘汰策略的 Get 也会修改内部结构，只能加互斥锁）
 type cache struct {
-	//互斥锁
-	mutex      sync.Mutex
-	lru        *lru.Cache
+	//第一次使用时创建分片
+	once   sync.Once
+	shards []*shard
+	//分片个数，不设置时为 1
+	nshards    int
+	newPolicy  policy.Factory
 	cacheBytes int64
+	//resize 和 purge 替换分片的淘汰策略时加锁，同时保护 cacheBytes
+	resizeMu sync.Mutex
+	//记录被淘汰时的回调，reason 说明淘汰原因
+	onEvicted func(key string, value ByteView, reason policy.EvictReason)
+}
+
+// 每个分片有自己的淘汰策略，内存上限是总内存的 1/nshards
+type shard struct {
+	//互斥锁
+	mutex sync.Mutex
+	store policy.Policy
+	//查找次数、命中次数和淘汰次数
+	nget, nhit, nevict int64
+}
+
+// 某个 cache 的统计信息
+type CacheStats struct {
+	Bytes     int64
+	Items     int64
+	Gets      int64
+	Hits      int64
+	Evictions int64
+}
+
+func (c *cache) init() {
+	n := c.nshards
+	if n <= 0 {
+		n = 1
+	}
+	//每个分片至少 1 字节，内存太小时减少分片个数
+	if c.cacheBytes > 0 && int64(n) > c.cacheBytes {
+		n = int(c.cacheBytes)
+	}
+	c.shards = make([]*shard, n)
+	for i := range c.shards {
+		s := &shard{}
+		s.store = c.newStore(s)
+		c.shards[i] = s
+	}
 }
 
-// 外层封装了Add方法
-func (c *cache) add(key string, value ByteView) error {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+// 为分片 s 创建空的淘汰策略
+func (c *cache) newStore(s *shard) policy.Policy {
+	newPolicy := c.newPolicy
+	if newPolicy == nil {
+		//默认使用 LRU
+		newPolicy = lru.NewPolicy
+	}
+	return newPolicy(c.shardBytes(), func(key string, value policy.Value, reason policy.EvictReason) {
+		//回调时已经持有分片的锁
+		if reason != policy.EvictRemoved {
+			s.nevict++
+		}
+		if c.onEvicted != nil {
+			c.onEvicted(key, value.(ByteView), reason)
+		}
+	})
+}
+
+// 每个分片的内存上限
+// 0 表示不限制，所以有限制时至少为 1，resize 之后分片个数不变，总内存可能比 cacheBytes 多几个字节
+func (c *cache) shardBytes() int64 {
+	n := c.cacheBytes / int64(len(c.shards))
+	if n == 0 && c.cacheBytes > 0 {
+		n = 1
+	}
+	return n
+}
 
-	if c.lru == nil {
-		c.lru = lru.New(c.cacheBytes, nil)
+// 根据 key 的哈希值选择分片
+func (c *cache) shard(key string) *shard {
+	c.once.Do(c.init)
+	if len(c.shards) == 1 {
+		return c.shards[0]
 	}
-	c.lru.Add(key, value)
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return c.shards[h.Sum32()%uint32(len(c.shards))]
+}
+
+// 外层封装了Add方法，expire 为零值表示永不过期
+func (c *cache) add(key string, value ByteView, expire time.Time) error {
+	s := c.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	value.e = expire
+	s.store.AddWithExpire(key, value, expire)
 	return nil
 }
 
 // 外层封装了Get方法
 func (c *cache) get(key string) (value ByteView, ok bool) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+	s := c.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	if c.lru == nil {
-		return
-	}
-
-	if v, ok := c.lru.Get(key); ok {
+	s.nget++
+	if v, ok := s.store.Get(key); ok {
+		s.nhit++
 		return v.(ByteView), ok
 	}
 
 	return
 }
+
+// 外层封装了RemoveKey方法
+func (c *cache) remove(key string) bool {
+	s := c.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.store.RemoveKey(key)
+}
+
+// 清理所有分片中已经过期的记录，返回清理的个数
+func (c *cache) removeExpired(now time.Time) int {
+	c.once.Do(c.init)
+	n := 0
+	for _, s := range c.shards {
+		s.mutex.Lock()
+		n += s.store.RemoveExpired(now)
+		s.mutex.Unlock()
+	}
+	return n
+}
+
+// 汇总所有分片的统计信息
+func (c *cache) stats() CacheStats {
+	c.once.Do(c.init)
+	var st CacheStats
+	for _, s := range c.shards {
+		s.mutex.Lock()
+		st.Gets += s.nget
+		st.Hits += s.nhit
+		st.Evictions += s.nevict
+		st.Bytes += s.store.Bytes()
+		st.Items += int64(s.store.Len())
+		s.mutex.Unlock()
+	}
+	return st
+}
+
+// 缓存中的一条记录，用于快照
+type cacheEntry struct {
+	key   string
+	value ByteView
+}
+
+// 按淘汰顺序复制所有分片中未过期的记录，淘汰策略没有实现 policy.Ranger 时返回 false
+// 复制时只持有当前分片的锁，不会阻塞其他分片的读写
+func (c *cache) entries(now time.Time) ([]cacheEntry, bool) {
+	c.once.Do(c.init)
+	var entries []cacheEntry
+	for _, s := range c.shards {
+		s.mutex.Lock()
+		r, ok := s.store.(policy.Ranger)
+		if !ok {
+			s.mutex.Unlock()
+			return nil, false
+		}
+		r.Range(func(key string, value policy.Value, expire time.Time) bool {
+			if !policy.Expired(expire, now) {
+				entries = append(entries, cacheEntry{key: key, value: value.(ByteView)})
+			}
+			return true
+		})
+		s.mutex.Unlock()
+	}
+	return entries, true
+}
+
+// 修改内存上限，淘汰策略既没有实现 policy.Resizer 也没有实现 policy.Ranger 时返回 false
+// 实现了 policy.Resizer 的策略原地调整；其余的每个分片换成新的淘汰策略，
+// 再按淘汰顺序写回原来的记录，缩小时最先被淘汰的记录先被移除
+func (c *cache) resize(cacheBytes int64) bool {
+	c.once.Do(c.init)
+	c.resizeMu.Lock()
+	defer c.resizeMu.Unlock()
+	for _, s := range c.shards {
+		s.mutex.Lock()
+		_, ok := s.store.(policy.Ranger)
+		if !ok {
+			_, ok = s.store.(policy.Resizer)
+		}
+		s.mutex.Unlock()
+		if !ok {
+			return false
+		}
+	}
+	c.cacheBytes = cacheBytes
+	now := time.Now()
+	for _, s := range c.shards {
+		s.mutex.Lock()
+		//能原地调整的策略保留访问频次等状态，其余的按淘汰顺序重新添加
+		if r, ok := s.store.(policy.Resizer); ok {
+			r.Resize(c.shardBytes())
+			s.mutex.Unlock()
+			continue
+		}
+		old := s.store.(policy.Ranger)
+		s.store = c.newStore(s)
+		old.Range(func(key string, value policy.Value, expire time.Time) bool {
+			if !policy.Expired(expire, now) {
+				s.store.AddWithExpire(key, value, expire)
+			}
+			return true
+		})
+		s.mutex.Unlock()
+	}
+	return true
+}
+
+// 清空所有分片，不会触发淘汰回调
+func (c *cache) purge() {
+	c.once.Do(c.init)
+	c.resizeMu.Lock()
+	defer c.resizeMu.Unlock()
+	for _, s := range c.shards {
+		s.mutex.Lock()
+		s.store = c.newStore(s)
+		s.mutex.Unlock()
+	}
+}
+
+// 当前的内存上限
+func (c *cache) capacity() int64 {
+	c.resizeMu.Lock()
+	defer c.resizeMu.Unlock()
+	return c.cacheBytes
+}
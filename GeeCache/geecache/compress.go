@@ -0,0 +1,163 @@
+package geecache
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// 压缩算法，用于缩小 mainCache 中保存的值和节点之间传输的值
+// 节点之间按 Name 识别压缩算法，自定义的算法需要在所有节点上通过 RegisterCompressor 注册
+type Compressor interface {
+	Name() string
+	Compress(src []byte) ([]byte, error)
+	//解压后超过 maxSize 时返回 ErrValueTooLarge，maxSize 为 0 表示不限制
+	Decompress(src []byte, maxSize int64) ([]byte, error)
+}
+
+var (
+	compressorsMu sync.RWMutex
+	//内置的压缩算法，可以按名字选择
+	compressors = map[string]Compressor{
+		"flate": flateCompressor{level: flate.BestSpeed},
+		"gzip":  gzipCompressor{},
+	}
+)
+
+// 注册自定义的压缩算法，同名的算法会被替换
+func RegisterCompressor(c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	compressors[c.Name()] = c
+}
+
+// 根据名字查找压缩算法
+func CompressorByName(name string) (Compressor, bool) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	c, ok := compressors[name]
+	return c, ok
+}
+
+// DEFLATE，默认使用最快的压缩级别，适合对延迟敏感的场景
+type flateCompressor struct {
+	level int
+}
+
+func (c flateCompressor) Name() string {
+	return "flate"
+}
+
+func (c flateCompressor) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(src); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c flateCompressor) Decompress(src []byte, maxSize int64) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	return readLimited(r, maxSize)
+}
+
+// gzip，压缩率更高，但是更慢
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (gzipCompressor) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(src []byte, maxSize int64) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(r, maxSize)
+}
+
+// 读取全部内容，超过 maxSize 时返回 ErrValueTooLarge，防止恶意的压缩数据耗尽内存
+func readLimited(r io.Reader, maxSize int64) ([]byte, error) {
+	if maxSize <= 0 {
+		return io.ReadAll(r)
+	}
+	b, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > maxSize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrValueTooLarge, maxSize)
+	}
+	return b, nil
+}
+
+// 压缩后存入 mainCache，压缩之后没有变小的值保持原样
+func (g *Group) encode(v ByteView) ByteView {
+	if g.compressor == nil || v.z {
+		return v
+	}
+	b, err := g.compressor.Compress(v.b)
+	if err != nil || len(b) >= len(v.b) {
+		return v
+	}
+	return ByteView{b: b, e: v.e, z: true}
+}
+
+// 解压 mainCache 中保存的值
+func (g *Group) decode(v ByteView) (ByteView, error) {
+	if !v.z {
+		return v, nil
+	}
+	b, err := g.compressor.Decompress(v.b, g.maxValueSize)
+	if err != nil {
+		return ByteView{}, fmt.Errorf("geecache: decompressing value: %w", err)
+	}
+	return ByteView{b: b, e: v.e}, nil
+}
+
+// 解压远程节点返回的值，encoding 是远程节点使用的压缩算法
+func decodeWire(encoding string, b []byte, maxSize int64) ([]byte, error) {
+	if encoding == "" {
+		if maxSize > 0 && int64(len(b)) > maxSize {
+			return nil, fmt.Errorf("%w: %d bytes", ErrValueTooLarge, len(b))
+		}
+		return b, nil
+	}
+	c, ok := CompressorByName(encoding)
+	if !ok {
+		return nil, &peerError{err: fmt.Errorf("unknown encoding %q", encoding)}
+	}
+	return c.Decompress(b, maxSize)
+}
+
+// 发送给远程节点时使用的压缩算法名
+func (g *Group) wireEncoding(v ByteView) string {
+	if v.z {
+		return g.compressor.Name()
+	}
+	return ""
+}
@@ -2,6 +2,7 @@ package consistenthash
 
 import (
 	"hash/crc32"
+	"math"
 	"sort"
 	"strconv"
 )
@@ -9,59 +10,246 @@ import (
 // Hash函数，通过key（参数是data）计算值的存储位值
 type Hash func(data []byte) uint32
 
+// 一致性哈希环，不是并发安全的，由调用者加锁
+//
+// 两个虚拟节点的哈希值相同时，环上的这个位置属于名字较小的真实节点，
+// 结果和添加节点的顺序无关；它被删除之后位置交给另一个节点。
+// 32 位哈希在虚拟节点较多时碰撞并不罕见，可以用 New64 换成 64 位哈希
 type Map struct {
 	//对应的哈希函数
-	hash Hash
+	hash Hash64
 	//虚拟节点倍数
 	replicas int
-	//哈希环
-	keys []int
+	//哈希环，没有重复的值
+	keys []uint64
 	//虚拟节点与真实节点之间的映射表
 	//键是虚拟节点的哈希值，值是真实节点的名称
-	hashMap map[int]string
+	hashMap map[uint64]string
+	//发生碰撞的位置上所有的真实节点，按名字排序，第一个是 hashMap 中的节点
+	collisions map[uint64][]string
+	//真实节点的权重，节点有 replicas*weight 个虚拟节点
+	weights     map[string]int
+	totalWeight int
+	//有界负载模式的负载因子，0 表示不启用
+	loadFactor float64
+	//每个真实节点正在处理的请求数，由调用者通过 Inc 和 Done 维护
+	loads     map[string]int
+	totalLoad int
 }
 
 // 自定义虚拟节点倍数和 Hash 函数。
 func New(replicas int, f Hash) *Map {
-	m := &Map{
-		hash:     f,
-		replicas: replicas,
-		hashMap:  make(map[int]string),
-	}
-	if m.hash == nil {
+	if f == nil {
 		//默认为 crc32.ChecksumIEEE 算法
-		m.hash = crc32.ChecksumIEEE
+		f = crc32.ChecksumIEEE
+	}
+	return New64(replicas, func(data []byte) uint64 {
+		return uint64(f(data))
+	})
+}
+
+// 使用 64 位哈希的一致性哈希环，f 为 nil 时使用 FNV-1a 加 splitmix64，
+// 碰撞几乎不会发生，分布也比 crc32 均匀。所有节点必须使用同样的哈希函数
+func New64(replicas int, f Hash64) *Map {
+	if f == nil {
+		f = defaultHash64
+	}
+	return &Map{
+		hash:       f,
+		replicas:   replicas,
+		hashMap:    make(map[uint64]string),
+		collisions: make(map[uint64][]string),
+		weights:    make(map[string]int),
+		loads:      make(map[string]int),
 	}
-	return m
 }
 
 // 添加“真实”节点/机器的Add
-// 允许传入 0 或 多个真实节点的名称
+// 允许传入 0 或 多个真实节点的名称，权重都是 1，已经存在的节点会被忽略
 func (m *Map) Add(keys ...string) {
 	for _, key := range keys {
-		//对每一个真实的节点key，创建replicas个虚拟节点
-		for i := 0; i < m.replicas; i++ {
-			//虚拟节点的名称：编号+key
-			//m.hash() 计算虚拟节点的哈希值
-			hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
+		m.add(key, 1)
+	}
+	//环上的哈希值排序
+	m.sortKeys()
+}
+
+// 添加一个权重为 weight 的真实节点，它的虚拟节点个数是其他权重为 1 的节点的 weight 倍，
+// 分到的 key 也大致是 weight 倍。weight 小于 1 时按 1 处理，已经存在的节点会被忽略
+func (m *Map) AddWeighted(key string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	m.add(key, weight)
+	m.sortKeys()
+}
+
+func (m *Map) add(key string, weight int) {
+	if _, ok := m.weights[key]; ok {
+		return
+	}
+	m.weights[key] = weight
+	m.totalWeight += weight
+	//对每一个真实的节点key，创建replicas*weight个虚拟节点
+	for i := 0; i < m.replicas*weight; i++ {
+		//虚拟节点的名称：编号+key
+		//m.hash() 计算虚拟节点的哈希值
+		hash := m.hash([]byte(strconv.Itoa(i) + key))
+		owner, ok := m.hashMap[hash]
+		switch {
+		case !ok:
 			//添加到环上
 			m.keys = append(m.keys, hash)
 			//增加虚拟与真实之间的映射关系
 			//键是虚拟的，值是真实的
 			m.hashMap[hash] = key
+		case owner != key:
+			//和其他节点的虚拟节点碰撞，记下所有节点，名字最小的节点拥有这个位置
+			m.hashMap[hash] = m.claim(hash, owner, key)
 		}
+		//同一个节点的两个虚拟节点碰撞时只占一个位置
 	}
-	//环上的哈希值排序
-	sort.Ints(m.keys)
+}
+
+// 记录 key 也声明了 hash 这个位置，返回碰撞之后拥有它的节点
+func (m *Map) claim(hash uint64, owner, key string) string {
+	nodes := m.collisions[hash]
+	if nodes == nil {
+		nodes = []string{owner}
+	}
+	i := sort.SearchStrings(nodes, key)
+	if i == len(nodes) || nodes[i] != key {
+		nodes = append(nodes, "")
+		copy(nodes[i+1:], nodes[i:])
+		nodes[i] = key
+	}
+	m.collisions[hash] = nodes
+	return nodes[0]
+}
+
+func (m *Map) sortKeys() {
+	sort.Slice(m.keys, func(i, j int) bool { return m.keys[i] < m.keys[j] })
+}
+
+// 返回环上发生碰撞的位置个数，每个位置至少有两个真实节点
+func (m *Map) Collisions() int {
+	return len(m.collisions)
+}
+
+// 删除“真实”节点以及它的所有虚拟节点，其他节点的位置不变
+// 和它碰撞的位置交给剩下的节点中名字最小的一个
+func (m *Map) Remove(keys ...string) {
+	removed := false
+	for _, key := range keys {
+		weight, ok := m.weights[key]
+		if !ok {
+			continue
+		}
+		delete(m.weights, key)
+		m.totalWeight -= weight
+		m.totalLoad -= m.loads[key]
+		delete(m.loads, key)
+		for i := 0; i < m.replicas*weight; i++ {
+			hash := m.hash([]byte(strconv.Itoa(i) + key))
+			if nodes, ok := m.collisions[hash]; ok {
+				m.unclaim(hash, nodes, key)
+				continue
+			}
+			if m.hashMap[hash] == key {
+				delete(m.hashMap, hash)
+				removed = true
+			}
+		}
+	}
+	if !removed {
+		return
+	}
+	//环上只保留还有映射关系的虚拟节点，原来就是有序的，不需要重新排序
+	keep := m.keys[:0]
+	for _, hash := range m.keys {
+		if _, ok := m.hashMap[hash]; ok {
+			keep = append(keep, hash)
+		}
+	}
+	m.keys = keep
+}
+
+// 从碰撞的位置上去掉 key，位置交给剩下的节点中名字最小的一个
+func (m *Map) unclaim(hash uint64, nodes []string, key string) {
+	i := sort.SearchStrings(nodes, key)
+	if i == len(nodes) || nodes[i] != key {
+		return
+	}
+	nodes = append(nodes[:i], nodes[i+1:]...)
+	m.hashMap[hash] = nodes[0]
+	if len(nodes) == 1 {
+		delete(m.collisions, hash)
+		return
+	}
+	m.collisions[hash] = nodes
+}
+
+// 启用有界负载（consistent hashing with bounded loads）：
+// 每个节点最多处理 ceil(factor * (总负载+1) * 权重 / 总权重) 个请求，
+// Get 跳过已经达到上限的节点，顺时针交给下一个节点。factor 必须大于 1，小于等于 1 时关闭
+func (m *Map) SetLoadFactor(factor float64) {
+	if factor <= 1 {
+		factor = 0
+	}
+	m.loadFactor = factor
+}
+
+// 节点开始处理一个请求，有界负载模式下 Get 之后调用
+func (m *Map) Inc(node string) {
+	if _, ok := m.weights[node]; !ok {
+		return
+	}
+	m.loads[node]++
+	m.totalLoad++
+}
+
+// 节点处理完一个请求
+func (m *Map) Done(node string) {
+	if m.loads[node] <= 0 {
+		return
+	}
+	m.loads[node]--
+	m.totalLoad--
+}
+
+// 节点正在处理的请求数
+func (m *Map) Load(node string) int {
+	return m.loads[node]
+}
+
+// 有界负载模式下节点是否已经达到上限，再分给它一个请求就会超过上限
+func (m *Map) Full(node string) bool {
+	if m.loadFactor <= 0 {
+		return false
+	}
+	if _, ok := m.weights[node]; !ok {
+		return false
+	}
+	return m.loads[node]+1 > m.capacity(node)
+}
+
+// 有界负载模式下节点能处理的请求数上限
+func (m *Map) capacity(node string) int {
+	avg := float64(m.totalLoad+1) / float64(m.totalWeight)
+	return int(math.Ceil(m.loadFactor * avg * float64(m.weights[node])))
 }
 
 // 选择节点的 Get() 方法
+// 启用有界负载时返回从 key 顺时针第一个没有达到上限的节点
 func (m *Map) Get(key string) string {
 	if len(m.keys) == 0 {
 		return ""
 	}
+	if m.loadFactor > 0 {
+		return m.getBounded(key)
+	}
 	//m.hash计算key的哈希值
-	hash := int(m.hash([]byte(key)))
+	hash := m.hash([]byte(key))
 	//顺时针查找到第一个匹配的虚拟节点的下标
 	idx := sort.Search(len(m.keys), func(i int) bool {
 		return m.keys[i] >= hash
@@ -70,3 +258,59 @@ func (m *Map) Get(key string) string {
 	//当idx==len(m.keys)的时候，应该选择m.keys[0],必须用取余的方式处理
 	return m.hashMap[m.keys[idx%len(m.keys)]]
 }
+
+func (m *Map) getBounded(key string) string {
+	var first, picked string
+	m.Walk(key, func(node string) bool {
+		if first == "" {
+			first = node
+		}
+		if !m.Full(node) {
+			picked = node
+			return false
+		}
+		return true
+	})
+	//负载因子大于 1 时总有节点没有达到上限，这里只是兜底
+	if picked == "" {
+		return first
+	}
+	return picked
+}
+
+// 返回从 key 顺时针遇到的 n 个不同的真实节点，第一个和 Get 不启用有界负载时的结果相同
+// 真实节点不足 n 个时返回所有节点，用于把 key 保存在多个节点上
+func (m *Map) GetN(key string, n int) []string {
+	if n <= 0 {
+		return nil
+	}
+	nodes := make([]string, 0, n)
+	m.Walk(key, func(node string) bool {
+		nodes = append(nodes, node)
+		return len(nodes) < n
+	})
+	return nodes
+}
+
+// 从 key 所在的位置开始顺时针遍历真实节点，每个节点只访问一次
+// fn 返回 false 时停止遍历，用于 key 的所属节点不可用时寻找下一个节点
+func (m *Map) Walk(key string, fn func(node string) bool) {
+	if len(m.keys) == 0 {
+		return
+	}
+	hash := m.hash([]byte(key))
+	idx := sort.Search(len(m.keys), func(i int) bool {
+		return m.keys[i] >= hash
+	})
+	seen := make(map[string]bool)
+	for i := 0; i < len(m.keys); i++ {
+		node := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if seen[node] {
+			continue
+		}
+		seen[node] = true
+		if !fn(node) {
+			return
+		}
+	}
+}
@@ -1,8 +1,16 @@
 package consistenthash
 
 import (
+	"fmt"
+	"hash/crc32"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"testing"
+	"testing/quick"
 )
 
 func TestHashing(t *testing.T) {
@@ -37,3 +45,500 @@ func TestHashing(t *testing.T) {
 		}
 	}
 }
+
+func TestRemove(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+
+	// Adds 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("6", "4", "2")
+	hash.Remove("4")
+	if len(hash.keys) != 6 {
+		t.Fatalf("virtual nodes of 4 should be removed, got %v", hash.keys)
+	}
+
+	// 原来属于 4 的 key 顺时针交给下一个节点，其他 key 不受影响
+	testCases := map[string]string{
+		"2":  "2",
+		"3":  "6",
+		"11": "2",
+		"23": "6",
+		"27": "2",
+	}
+	for k, v := range testCases {
+		if hash.Get(k) != v {
+			t.Errorf("Asking for %s, should have yielded %s", k, v)
+		}
+	}
+
+	hash.Remove("6", "2", "unknown")
+	if hash.Get("2") != "" {
+		t.Fatalf("empty ring should yield nothing")
+	}
+}
+
+func TestWalk(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+
+	// Adds 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("6", "4", "2")
+	var nodes []string
+	hash.Walk("13", func(node string) bool {
+		nodes = append(nodes, node)
+		return true
+	})
+	if !reflect.DeepEqual(nodes, []string{"4", "6", "2"}) {
+		t.Fatalf("walk from 13 should yield 4, 6, 2, got %v", nodes)
+	}
+
+	nodes = nil
+	hash.Walk("25", func(node string) bool {
+		nodes = append(nodes, node)
+		return len(nodes) < 2
+	})
+	if !reflect.DeepEqual(nodes, []string{"6", "2"}) {
+		t.Fatalf("walk from 25 should stop after 6, 2, got %v", nodes)
+	}
+}
+
+func TestGetN(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	if nodes := hash.GetN("13", 2); len(nodes) != 0 {
+		t.Fatalf("empty ring should return no nodes, got %v", nodes)
+	}
+
+	// Adds 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("6", "4", "2")
+	cases := []struct {
+		key  string
+		n    int
+		want []string
+	}{
+		{"13", 1, []string{"4"}},
+		{"13", 2, []string{"4", "6"}},
+		{"25", 2, []string{"6", "2"}},
+		//真实节点不足 n 个时返回所有节点
+		{"25", 5, []string{"6", "2", "4"}},
+		{"25", 0, nil},
+	}
+	for _, c := range cases {
+		if got := hash.GetN(c.key, c.n); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("GetN(%q, %d) = %v, want %v", c.key, c.n, got, c.want)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		if nodes := hash.GetN(key, 2); nodes[0] != hash.Get(key) {
+			t.Fatalf("first owner of %s should be %s, got %v", key, hash.Get(key), nodes)
+		}
+	}
+}
+
+// 统计 n 个 key 在各个节点上的分布，返回每个节点分到的 key 个数
+func distribution(m *Map, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		counts[m.Get("key"+strconv.Itoa(i))]++
+	}
+	return counts
+}
+
+// 最多的节点和最少的节点分到的 key 相对于平均值的比例，以及标准差相对于平均值的比例
+func spread(counts map[string]int, nodes int) (max, min, stddev float64) {
+	mean := 0.0
+	for _, c := range counts {
+		mean += float64(c)
+	}
+	mean /= float64(nodes)
+	min = math.Inf(1)
+	for i := 0; i < nodes; i++ {
+		c := float64(counts["node"+strconv.Itoa(i)])
+		max = math.Max(max, c/mean)
+		min = math.Min(min, c/mean)
+		stddev += (c - mean) * (c - mean)
+	}
+	return max, min, math.Sqrt(stddev/float64(nodes)) / mean
+}
+
+// 分析不同虚拟节点倍数下 key 的分布，go test -v -run Distribution 查看报告
+// 默认的 crc32 对相似的虚拟节点名称分布不够均匀，倍数超过 50 之后改善有限
+func TestDistribution(t *testing.T) {
+	const nodes, keys = 10, 100000
+	var first, last float64
+	for _, replicas := range []int{1, 10, 50, 200} {
+		m := New(replicas, nil)
+		for i := 0; i < nodes; i++ {
+			m.Add("node" + strconv.Itoa(i))
+		}
+		max, min, stddev := spread(distribution(m, keys), nodes)
+		t.Logf("replicas=%-4d max/mean=%.2f min/mean=%.2f stddev/mean=%.3f", replicas, max, min, stddev)
+		if replicas >= 50 && max > 1.5 {
+			t.Errorf("replicas=%d: the busiest node gets %.2fx the mean", replicas, max)
+		}
+		if first == 0 {
+			first = stddev
+		}
+		last = stddev
+	}
+	if last > first/4 {
+		t.Errorf("more replicas should spread keys more evenly, stddev/mean %.3f -> %.3f", first, last)
+	}
+}
+
+func TestAddWeighted(t *testing.T) {
+	m := New(50, nil)
+	m.Add("node0", "node1")
+	m.AddWeighted("node2", 2)
+	//重复添加不改变节点
+	m.AddWeighted("node2", 5)
+	if len(m.keys) != 4*50 {
+		t.Fatalf("expect 200 virtual nodes, got %d", len(m.keys))
+	}
+	counts := distribution(m, 100000)
+	ratio := float64(counts["node2"]) / float64(counts["node0"]+counts["node1"]) * 2
+	t.Logf("weighted distribution %v, node2/avg=%.2f", counts, ratio)
+	if ratio < 1.5 || ratio > 2.5 {
+		t.Fatalf("node2 with weight 2 should get about twice the keys, got %.2fx", ratio)
+	}
+
+	m.Remove("node2")
+	if len(m.keys) != 2*50 {
+		t.Fatalf("all virtual nodes of node2 should be removed, got %d left", len(m.keys))
+	}
+	if counts := distribution(m, 1000); counts["node2"] != 0 {
+		t.Fatalf("removed node should not get keys")
+	}
+}
+
+func TestBoundedLoad(t *testing.T) {
+	m := New(50, nil)
+	for i := 0; i < 4; i++ {
+		m.Add("node" + strconv.Itoa(i))
+	}
+	m.SetLoadFactor(1.25)
+	owner := m.Get("hot")
+
+	//同一个热点 key 的请求不会全部压到一个节点上
+	for i := 0; i < 100; i++ {
+		node := m.Get("hot")
+		m.Inc(node)
+		for j := 0; j < 4; j++ {
+			n := "node" + strconv.Itoa(j)
+			if limit := int(math.Ceil(1.25 * float64(m.totalLoad) / 4)); m.Load(n) > limit {
+				t.Fatalf("%s has %d requests, more than %d", n, m.Load(n), limit)
+			}
+		}
+	}
+	if m.Load(owner) == 100 || m.Load(owner) < 25 {
+		t.Fatalf("owner should take its share but not everything, got %d", m.Load(owner))
+	}
+	if next := m.Get("hot"); next != owner && !m.Full(owner) {
+		t.Fatalf("%s is skipped, it should be full", owner)
+	}
+
+	//负载下降之后回到原来的节点
+	for j := 0; j < 4; j++ {
+		n := "node" + strconv.Itoa(j)
+		for m.Load(n) > 0 {
+			m.Done(n)
+		}
+	}
+	if m.totalLoad != 0 || m.Get("hot") != owner {
+		t.Fatalf("key should go back to %s when the load drops", owner)
+	}
+	m.SetLoadFactor(0)
+	if m.Full(owner) || m.Get("hot") != owner {
+		t.Fatalf("disabling bounded load should restore plain lookups")
+	}
+}
+
+func TestCollisions(t *testing.T) {
+	//所有虚拟节点只落在 4 个位置上，必然碰撞
+	hash := func(key []byte) uint32 {
+		return crc32.ChecksumIEEE(key) % 4
+	}
+	a, b := New(3, hash), New(3, hash)
+	a.Add("a", "b", "c")
+	b.Add("c", "b", "a")
+	if a.Collisions() == 0 {
+		t.Fatalf("expect collisions with a 2-bit hash")
+	}
+	for i := 1; i < len(a.keys); i++ {
+		if a.keys[i] <= a.keys[i-1] {
+			t.Fatalf("ring should not contain duplicates, got %v", a.keys)
+		}
+	}
+	//碰撞的位置属于名字最小的节点，和添加的顺序无关
+	for hash, nodes := range a.collisions {
+		if !sort.StringsAreSorted(nodes) || a.hashMap[hash] != nodes[0] {
+			t.Fatalf("position %d should belong to the smallest of %v, got %s", hash, nodes, a.hashMap[hash])
+		}
+	}
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		if a.Get(key) != b.Get(key) {
+			t.Fatalf("%s: lookups should not depend on the order of Add", key)
+		}
+	}
+
+	//删除节点之后和只添加剩下的节点的结果相同
+	a.Remove("a")
+	fresh := New(3, hash)
+	fresh.Add("b", "c")
+	if !reflect.DeepEqual(a.keys, fresh.keys) || !reflect.DeepEqual(a.hashMap, fresh.hashMap) ||
+		!reflect.DeepEqual(a.collisions, fresh.collisions) {
+		t.Fatalf("removing a node should hand its positions over, got %v %v, want %v %v",
+			a.hashMap, a.collisions, fresh.hashMap, fresh.collisions)
+	}
+	a.Remove("b", "c")
+	if len(a.keys) != 0 || len(a.hashMap) != 0 || a.Collisions() != 0 {
+		t.Fatalf("empty ring should have no positions left, got %v", a.hashMap)
+	}
+}
+
+// 随机的节点个数和 key 上验证环的性质，固定随机数种子保证结果可以复现
+func TestRingProperties(t *testing.T) {
+	config := &quick.Config{MaxCount: 30, Rand: rand.New(rand.NewSource(1))}
+	randomKeys := func(seed int64, n int) []string {
+		r := rand.New(rand.NewSource(seed))
+		keys := make([]string, n)
+		for i := range keys {
+			keys[i] = strconv.FormatUint(r.Uint64(), 36)
+		}
+		return keys
+	}
+
+	//64 位哈希下每个节点分到大约 1/N 的 key
+	balanced := func(n uint8, seed int64) bool {
+		nodes := int(n)%9 + 2
+		m := New64(160, nil)
+		m.Add(nodeNames(nodes)...)
+		counts := make(map[string]int)
+		for _, key := range randomKeys(seed, 20000) {
+			counts[m.Get(key)]++
+		}
+		max, min, _ := spread(counts, nodes)
+		return len(counts) == nodes && max < 1.4 && min > 0.6
+	}
+	if err := quick.Check(balanced, config); err != nil {
+		t.Errorf("keys should be spread evenly: %v", err)
+	}
+
+	//增加节点时只有 key 移动到新节点，其他 key 的所属节点不变，碰撞很多的哈希也一样
+	rings := map[string]func() *Map{
+		"crc32":   func() *Map { return New(50, nil) },
+		"64-bit":  func() *Map { return New64(50, nil) },
+		"collide": func() *Map { return New(50, func(key []byte) uint32 { return crc32.ChecksumIEEE(key) % 512 }) },
+	}
+	for name, newRing := range rings {
+		newRing := newRing
+		onlyToNewNode := func(n uint8, seed int64) bool {
+			nodes := nodeNames(int(n)%9 + 2)
+			//新节点不一定排在最后，碰撞时名字较小的节点会抢走位置
+			added := nodes[int(seed&0x7fffffff)%len(nodes)]
+			before, after := newRing(), newRing()
+			for _, node := range nodes {
+				if node != added {
+					before.Add(node)
+				}
+			}
+			after.Add(nodes...)
+			for _, key := range randomKeys(seed, 2000) {
+				if owner := after.Get(key); owner != added && owner != before.Get(key) {
+					return false
+				}
+			}
+			return true
+		}
+		if err := quick.Check(onlyToNewNode, config); err != nil {
+			t.Errorf("%s: adding a node should only move keys to it: %v", name, err)
+		}
+	}
+}
+
+// 所有的 Picker 实现
+var pickers = []struct {
+	name string
+	new  func() Picker
+}{
+	{"ring", func() Picker { return New(50, nil) }},
+	{"ring64", func() Picker { return New64(50, nil) }},
+	{"rendezvous", func() Picker { return NewRendezvous(nil) }},
+	{"jump", func() Picker { return NewJump(nil) }},
+	{"maglev", func() Picker { return NewMaglev(0, nil) }},
+}
+
+func nodeNames(n int) []string {
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = "node" + strconv.Itoa(i)
+	}
+	return nodes
+}
+
+func TestPickers(t *testing.T) {
+	for _, p := range pickers {
+		m := p.new()
+		if m.Get("key") != "" {
+			t.Fatalf("%s: empty picker should yield nothing", p.name)
+		}
+		nodes := nodeNames(5)
+		m.Add(nodes...)
+		m.Add("node0")
+		for i := 0; i < 100; i++ {
+			key := "key" + strconv.Itoa(i)
+			var walked []string
+			m.Walk(key, func(node string) bool {
+				walked = append(walked, node)
+				return true
+			})
+			if len(walked) != 5 || walked[0] != m.Get(key) {
+				t.Fatalf("%s: walk should start at %s and visit 5 nodes once, got %v", p.name, m.Get(key), walked)
+			}
+			seen := make(map[string]bool)
+			for _, node := range walked {
+				if seen[node] {
+					t.Fatalf("%s: walk visited %s twice", p.name, node)
+				}
+				seen[node] = true
+			}
+		}
+
+		before := make(map[string]string)
+		for i := 0; i < 1000; i++ {
+			key := "key" + strconv.Itoa(i)
+			before[key] = m.Get(key)
+		}
+		m.Remove("node2", "unknown")
+		for key, owner := range before {
+			if now := m.Get(key); now == "node2" || now == "" {
+				t.Fatalf("%s: %s should move away from the removed node, got %q", p.name, key, now)
+			} else if owner != "node2" && now != owner && p.name != "jump" && p.name != "maglev" {
+				t.Fatalf("%s: %s moved from %s to %s", p.name, key, owner, now)
+			}
+		}
+		m.Remove(nodes...)
+		if m.Get("key") != "" {
+			t.Fatalf("%s: picker should be empty after removing all nodes", p.name)
+		}
+	}
+}
+
+// 除了 Jump，其他算法的结果和 Add 的顺序无关，节点之间不需要约定顺序
+func TestMaglevSize(t *testing.T) {
+	for _, size := range []int{1, 4, 65536} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("table size %d should be rejected", size)
+				}
+			}()
+			NewMaglev(size, nil)
+		}()
+	}
+	//最小的合法大小，所有 key 都属于同一个位置上的节点
+	m := NewMaglev(2, nil)
+	m.Add("node0", "node1")
+	if m.Get("key") == "" {
+		t.Fatalf("table of size 2 should work")
+	}
+}
+
+func TestPickerAddOrder(t *testing.T) {
+	for _, p := range pickers {
+		a, b := p.new(), p.new()
+		a.Add("node0", "node1", "node2", "node3")
+		b.Add("node3", "node1")
+		b.Add("node2", "node0")
+		for i := 0; i < 1000; i++ {
+			key := "key" + strconv.Itoa(i)
+			if a.Get(key) != b.Get(key) {
+				t.Fatalf("%s: %s depends on the order of Add", p.name, key)
+			}
+		}
+	}
+}
+
+// 比较各个算法在增删节点时移动的 key 的比例和分布的均匀程度，go test -v -run Movement 查看报告
+func TestMovement(t *testing.T) {
+	const n, keys = 10, 100000
+	moved := func(m Picker, change func()) float64 {
+		before := make([]string, keys)
+		for i := range before {
+			before[i] = m.Get("key" + strconv.Itoa(i))
+		}
+		change()
+		count := 0
+		for i, owner := range before {
+			if m.Get("key"+strconv.Itoa(i)) != owner {
+				count++
+			}
+		}
+		return float64(count) / keys
+	}
+	for _, p := range pickers {
+		m := p.new()
+		m.Add(nodeNames(n)...)
+		counts := make(map[string]int)
+		for i := 0; i < keys; i++ {
+			counts[m.Get("key"+strconv.Itoa(i))]++
+		}
+		max, _, stddev := spread(counts, n)
+		//新节点的名称排在最后，Jump 只有这样才是最少移动
+		added := moved(m, func() { m.Add("node99") })
+		removed := moved(m, func() { m.Remove("node3") })
+		t.Logf("%-10s max/mean=%.2f stddev/mean=%.3f moved on add=%.3f on remove=%.3f", p.name, max, stddev, added, removed)
+		//理想情况下增加第 11 个节点移动 1/11 的 key，删除一个节点移动 1/10
+		//Jump 删除中间的节点时后面的桶整体错位
+		if added > 0.2 || (p.name != "jump" && removed > 0.25) {
+			t.Errorf("%s moves too many keys: add %.3f, remove %.3f", p.name, added, removed)
+		}
+		if !strings.HasPrefix(p.name, "ring") && max > 1.1 {
+			t.Errorf("%s should spread keys evenly, the busiest node gets %.2fx the mean", p.name, max)
+		}
+	}
+}
+
+// 比较不同节点个数下各个算法的查找开销
+// go test -run none -bench PickerGet
+func BenchmarkPickerGet(b *testing.B) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+	for _, n := range []int{10, 100} {
+		for _, p := range pickers {
+			b.Run(fmt.Sprintf("%s/nodes=%d", p.name, n), func(b *testing.B) {
+				m := p.new()
+				m.Add(nodeNames(n)...)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					m.Get(keys[i%len(keys)])
+				}
+			})
+		}
+	}
+}
+
+// 比较增删节点的开销，Maglev 需要重建查找表
+func BenchmarkPickerMembership(b *testing.B) {
+	for _, p := range pickers {
+		b.Run(p.name, func(b *testing.B) {
+			m := p.new()
+			m.Add(nodeNames(10)...)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m.Add("node10")
+				m.Remove("node10")
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package consistenthash
+
+import "sort"
+
+// Jump 一致性哈希（Lamping & Veach）：不需要额外内存，计算 O(log n) 次就能得到 key 所在的桶
+// 节点按名称排序之后依次对应各个桶，结果和 Add 的顺序无关，不同节点上的 Jump 总是一致。
+// 新节点排在最后时只有 1/n 的 key 移动；插在中间或者删除中间的节点时，后面的桶整体错位，移动的 key 更多
+type Jump struct {
+	hash  Hash64
+	nodes []string
+}
+
+// 创建 Jump，hash 为 nil 时使用默认的 64 位 Hash
+func NewJump(hash Hash64) *Jump {
+	if hash == nil {
+		hash = defaultHash64
+	}
+	return &Jump{hash: hash}
+}
+
+func (j *Jump) Add(nodes ...string) {
+	for _, node := range nodes {
+		i := sort.SearchStrings(j.nodes, node)
+		if i < len(j.nodes) && j.nodes[i] == node {
+			continue
+		}
+		j.nodes = append(j.nodes, "")
+		copy(j.nodes[i+1:], j.nodes[i:])
+		j.nodes[i] = node
+	}
+}
+
+func (j *Jump) Remove(nodes ...string) {
+	for _, node := range nodes {
+		if i := sort.SearchStrings(j.nodes, node); i < len(j.nodes) && j.nodes[i] == node {
+			j.nodes = append(j.nodes[:i], j.nodes[i+1:]...)
+		}
+	}
+}
+
+// 论文中的算法，返回 key 在 n 个桶中的编号
+func jumpHash(key uint64, n int) int {
+	var b, i int64 = -1, 0
+	for i < int64(n) {
+		b = i
+		key = key*2862933555777941757 + 1
+		i = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+func (j *Jump) Get(key string) string {
+	if len(j.nodes) == 0 {
+		return ""
+	}
+	return j.nodes[jumpHash(j.hash([]byte(key)), len(j.nodes))]
+}
+
+// 从 key 所在的桶开始依次访问后面的桶
+func (j *Jump) Walk(key string, fn func(node string) bool) {
+	if len(j.nodes) == 0 {
+		return
+	}
+	b := jumpHash(j.hash([]byte(key)), len(j.nodes))
+	for i := 0; i < len(j.nodes); i++ {
+		if !fn(j.nodes[(b+i)%len(j.nodes)]) {
+			return
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package consistenthash
+
+import "sort"
+
+// Maglev 的默认查找表大小，必须是质数，并且远大于节点个数
+const defaultMaglevTableSize = 65537
+
+// Maglev 哈希（Google 的负载均衡器）：每个节点按自己的排列顺序轮流占据查找表中的位置，
+// 查找只需要一次取模和一次数组访问，每个节点占据的位置个数几乎相同。
+// 增删节点之后重建查找表，少量不属于这个节点的 key 也会移动
+type Maglev struct {
+	hash  Hash64
+	size  int
+	nodes []string
+	//查找表，保存节点在 nodes 中的下标
+	table []int
+}
+
+// 创建 Maglev，size 是查找表大小，必须是质数，<= 0 时使用 65537；hash 为 nil 时使用默认的 64 位 Hash
+// size 不是质数时节点的排列不一定能覆盖所有位置，建表会陷入死循环，所以直接 panic
+func NewMaglev(size int, hash Hash64) *Maglev {
+	if size <= 0 {
+		size = defaultMaglevTableSize
+	}
+	if !isPrime(size) {
+		panic("consistenthash: Maglev table size must be a prime")
+	}
+	if hash == nil {
+		hash = defaultHash64
+	}
+	return &Maglev{hash: hash, size: size}
+}
+
+func (m *Maglev) Add(nodes ...string) {
+	changed := false
+	for _, node := range nodes {
+		//nodes 按名称排序，查找表和 Add 的顺序无关
+		i := sort.SearchStrings(m.nodes, node)
+		if i < len(m.nodes) && m.nodes[i] == node {
+			continue
+		}
+		m.nodes = append(m.nodes, "")
+		copy(m.nodes[i+1:], m.nodes[i:])
+		m.nodes[i] = node
+		changed = true
+	}
+	if changed {
+		m.populate()
+	}
+}
+
+func (m *Maglev) Remove(nodes ...string) {
+	changed := false
+	for _, node := range nodes {
+		if i := sort.SearchStrings(m.nodes, node); i < len(m.nodes) && m.nodes[i] == node {
+			m.nodes = append(m.nodes[:i], m.nodes[i+1:]...)
+			changed = true
+		}
+	}
+	if changed {
+		m.populate()
+	}
+}
+
+// 重建查找表
+func (m *Maglev) populate() {
+	n := len(m.nodes)
+	if n == 0 {
+		m.table = nil
+		return
+	}
+	//每个节点的排列：offset + j*skip
+	offsets := make([]uint64, n)
+	skips := make([]uint64, n)
+	for i, node := range m.nodes {
+		h := m.hash([]byte(node))
+		offsets[i] = h % uint64(m.size)
+		skips[i] = mix64(h^0x9e3779b97f4a7c15)%uint64(m.size-1) + 1
+	}
+	table := make([]int, m.size)
+	for i := range table {
+		table[i] = -1
+	}
+	next := make([]uint64, n)
+	for filled := 0; ; {
+		for i := 0; i < n; i++ {
+			c := (offsets[i] + next[i]*skips[i]) % uint64(m.size)
+			for table[c] >= 0 {
+				next[i]++
+				c = (offsets[i] + next[i]*skips[i]) % uint64(m.size)
+			}
+			table[c] = i
+			next[i]++
+			if filled++; filled == m.size {
+				m.table = table
+				return
+			}
+		}
+	}
+}
+
+func (m *Maglev) Get(key string) string {
+	if len(m.table) == 0 {
+		return ""
+	}
+	return m.nodes[m.table[m.hash([]byte(key))%uint64(m.size)]]
+}
+
+// 从 key 所在的位置开始依次访问查找表中的其他节点
+func (m *Maglev) Walk(key string, fn func(node string) bool) {
+	if len(m.table) == 0 {
+		return
+	}
+	idx := int(m.hash([]byte(key)) % uint64(m.size))
+	seen := make([]bool, len(m.nodes))
+	visited := 0
+	for i := 0; i < m.size && visited < len(m.nodes); i++ {
+		n := m.table[(idx+i)%m.size]
+		if seen[n] {
+			continue
+		}
+		seen[n] = true
+		visited++
+		if !fn(m.nodes[n]) {
+			return
+		}
+	}
+}
+
+func isPrime(n int) bool {
+	if n < 2 {
+		return false
+	}
+	for i := 2; i*i <= n; i++ {
+		if n%i == 0 {
+			return false
+		}
+	}
+	return true
+}
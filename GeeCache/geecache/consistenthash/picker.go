@@ -0,0 +1,61 @@
+package consistenthash
+
+// 根据 key 选择节点的算法，节点池通过它把 key 映射到节点
+// 实现不需要是并发安全的，由调用者加锁。所有节点必须使用同样的算法和参数
+type Picker interface {
+	//增加节点，已经存在的节点会被忽略
+	Add(nodes ...string)
+	//删除节点
+	Remove(nodes ...string)
+	//返回 key 所属的节点，没有节点时返回空字符串
+	Get(key string) string
+	//从 key 所属的节点开始依次访问其他节点，每个节点只访问一次，fn 返回 false 时停止
+	Walk(key string, fn func(node string) bool)
+}
+
+// 可以给节点设置权重的 Picker，权重越大分到的 key 越多
+type WeightedPicker interface {
+	Picker
+	AddWeighted(node string, weight int)
+}
+
+// 支持有界负载的 Picker：调用者在访问节点前后调用 Inc 和 Done，
+// 启用之后 Get 跳过已经达到上限的节点
+type LoadBalancer interface {
+	Picker
+	SetLoadFactor(factor float64)
+	Inc(node string)
+	Done(node string)
+	//有界负载模式下节点是否已经达到上限，没有启用时总是返回 false
+	Full(node string) bool
+}
+
+var _ Picker = (*Map)(nil)
+var _ Picker = (*Rendezvous)(nil)
+var _ Picker = (*Jump)(nil)
+var _ Picker = (*Maglev)(nil)
+var _ WeightedPicker = (*Map)(nil)
+var _ LoadBalancer = (*Map)(nil)
+
+// 64 位的 Hash 函数，用于 New64、Rendezvous、Jump 和 Maglev
+type Hash64 func(data []byte) uint64
+
+// 默认的 64 位 Hash：FNV-1a 之后再用 splitmix64 的终结函数打散，相似的输入也能均匀分布
+func defaultHash64(data []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range data {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	return mix64(h)
+}
+
+// splitmix64 的终结函数
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,92 @@
+package consistenthash
+
+import "sort"
+
+// Rendezvous（HRW，最高随机权重）哈希：key 属于和它组合之后得分最高的节点
+// 不需要虚拟节点，分布均匀；增删节点时只有这个节点上的 key 移动。代价是每次查找需要计算所有节点的得分
+type Rendezvous struct {
+	hash  Hash64
+	nodes []string
+	//节点名称的哈希值，和 key 的哈希值组合得到得分
+	seeds []uint64
+}
+
+// 创建 Rendezvous，hash 为 nil 时使用默认的 64 位 Hash
+func NewRendezvous(hash Hash64) *Rendezvous {
+	if hash == nil {
+		hash = defaultHash64
+	}
+	return &Rendezvous{hash: hash}
+}
+
+func (r *Rendezvous) Add(nodes ...string) {
+	for _, node := range nodes {
+		if r.index(node) >= 0 {
+			continue
+		}
+		r.nodes = append(r.nodes, node)
+		r.seeds = append(r.seeds, r.hash([]byte(node)))
+	}
+}
+
+func (r *Rendezvous) Remove(nodes ...string) {
+	for _, node := range nodes {
+		if i := r.index(node); i >= 0 {
+			r.nodes = append(r.nodes[:i], r.nodes[i+1:]...)
+			r.seeds = append(r.seeds[:i], r.seeds[i+1:]...)
+		}
+	}
+}
+
+func (r *Rendezvous) index(node string) int {
+	for i, n := range r.nodes {
+		if n == node {
+			return i
+		}
+	}
+	return -1
+}
+
+// 节点 i 对 key 的得分，得分相同时比较节点名称，保证所有节点的选择一致
+func (r *Rendezvous) score(h uint64, i int) uint64 {
+	return mix64(h ^ r.seeds[i])
+}
+
+func (r *Rendezvous) Get(key string) string {
+	h := r.hash([]byte(key))
+	best := -1
+	var bestScore uint64
+	for i := range r.nodes {
+		s := r.score(h, i)
+		if best < 0 || s > bestScore || (s == bestScore && r.nodes[i] > r.nodes[best]) {
+			best, bestScore = i, s
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+	return r.nodes[best]
+}
+
+// 按得分从高到低访问节点
+func (r *Rendezvous) Walk(key string, fn func(node string) bool) {
+	h := r.hash([]byte(key))
+	order := make([]int, len(r.nodes))
+	scores := make([]uint64, len(r.nodes))
+	for i := range r.nodes {
+		order[i] = i
+		scores[i] = r.score(h, i)
+	}
+	sort.Slice(order, func(a, b int) bool {
+		i, j := order[a], order[b]
+		if scores[i] != scores[j] {
+			return scores[i] > scores[j]
+		}
+		return r.nodes[i] > r.nodes[j]
+	})
+	for _, i := range order {
+		if !fn(r.nodes[i]) {
+			return
+		}
+	}
+}
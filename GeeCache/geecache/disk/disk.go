@@ -0,0 +1,452 @@
+// 基于本地文件的缓存存储，作为内存之后的第二级缓存
+//
+// 所有写入都追加到同一个数据文件的末尾，内存中只保存 key 到文件位置的索引。
+// 删除和覆盖只是追加新的记录，旧记录变成垃圾，垃圾超过一半时在后台重写文件（压缩），
+// 压缩期间的写入不会被阻塞。
+// 每条记录的格式：
+//
+//	crc     uint32，后面所有字节的 CRC-32C
+//	flags   1 字节，flagDeleted 表示删除标记
+//	expire  int64，过期时间的 UnixNano，0 表示永不过期
+//	keyLen  uint32
+//	valLen  uint32
+//	key、value
+//
+// 进程崩溃时文件末尾可能有写了一半的记录，打开时会被截断。
+
+package disk
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	dataFile    = "geecache.data"
+	compactFile = "geecache.data.compact"
+	headerSize  = 4 + 1 + 8 + 4 + 4
+	flagDeleted = 1
+	//单个 key 或 value 的长度上限，防止损坏的文件导致分配过大的内存
+	maxFieldSize = 1 << 30
+	//文件小于这个大小时不压缩
+	defaultCompactMinBytes = 1 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// 记录已经损坏
+var ErrCorrupt = errors.New("disk: corrupt record")
+
+// 索引中的一项，指向文件中最新的记录
+type location struct {
+	key    string
+	offset int64
+	size   int64
+	expire int64
+}
+
+type Store struct {
+	mutex sync.Mutex
+	//同一时间只有一个压缩，先于 mutex 获取
+	compactMutex sync.Mutex
+	//后台压缩正在进行
+	compacting bool
+	closed     bool
+	dir        string
+	f          *os.File
+	//文件的大小，也是下一条记录的位置
+	size int64
+	//有效记录占用的字节数，超过 maxBytes 时淘汰最早写入的记录
+	live     int64
+	maxBytes int64
+	//文件大于这个大小且垃圾超过一半时压缩
+	compactMinBytes int64
+	index           map[string]*list.Element
+	//按写入顺序排列的 location，front 是最早写入的
+	order *list.List
+}
+
+// 打开 dir 中的数据文件，不存在时创建
+// maxBytes 是有效记录的上限，0 表示不限制
+func Open(dir string, maxBytes int64) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	//上一次压缩没有完成，丢弃写了一半的文件
+	os.Remove(filepath.Join(dir, compactFile))
+	f, err := os.OpenFile(filepath.Join(dir, dataFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		dir:             dir,
+		f:               f,
+		maxBytes:        maxBytes,
+		compactMinBytes: defaultCompactMinBytes,
+		index:           make(map[string]*list.Element),
+		order:           list.New(),
+	}
+	if err = s.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// 设置触发压缩的最小文件大小
+func (s *Store) SetCompactMinBytes(n int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.compactMinBytes = n
+}
+
+// 扫描数据文件重建索引，截断末尾不完整的记录
+func (s *Store) load() error {
+	r := bufio.NewReader(s.f)
+	now := time.Now().UnixNano()
+	var offset int64
+	for {
+		flags, expire, key, _, size, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			//写了一半或者损坏的记录，之后的内容都不可信
+			if err = s.f.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		//已经过期的记录和删除标记一样处理
+		if flags&flagDeleted != 0 || (expire != 0 && expire <= now) {
+			s.unlink(string(key))
+		} else {
+			s.link(&location{key: string(key), offset: offset, size: size, expire: expire})
+		}
+		offset += size
+	}
+	s.size = offset
+	_, err := s.f.Seek(offset, io.SeekStart)
+	return err
+}
+
+// 读取一条记录，返回记录的总长度
+func readRecord(r io.Reader) (flags byte, expire int64, key, value []byte, size int64, err error) {
+	header := make([]byte, headerSize)
+	if _, err = io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = ErrCorrupt
+		}
+		return
+	}
+	keyLen := binary.BigEndian.Uint32(header[13:17])
+	valLen := binary.BigEndian.Uint32(header[17:21])
+	if keyLen > maxFieldSize || valLen > maxFieldSize {
+		err = ErrCorrupt
+		return
+	}
+	body := make([]byte, int(keyLen)+int(valLen))
+	if _, err = io.ReadFull(r, body); err != nil {
+		err = ErrCorrupt
+		return
+	}
+	crc := crc32.Update(crc32.Checksum(header[4:], crcTable), crcTable, body)
+	if crc != binary.BigEndian.Uint32(header[:4]) {
+		err = ErrCorrupt
+		return
+	}
+	flags = header[4]
+	expire = int64(binary.BigEndian.Uint64(header[5:13]))
+	key, value = body[:keyLen], body[keyLen:]
+	size = int64(headerSize) + int64(len(body))
+	return
+}
+
+// 编码一条记录
+func encodeRecord(flags byte, expire int64, key string, value []byte) []byte {
+	buf := make([]byte, headerSize, headerSize+len(key)+len(value))
+	buf[4] = flags
+	binary.BigEndian.PutUint64(buf[5:13], uint64(expire))
+	binary.BigEndian.PutUint32(buf[13:17], uint32(len(key)))
+	binary.BigEndian.PutUint32(buf[17:21], uint32(len(value)))
+	buf = append(buf, key...)
+	buf = append(buf, value...)
+	binary.BigEndian.PutUint32(buf[:4], crc32.Checksum(buf[4:], crcTable))
+	return buf
+}
+
+// 把 loc 加入索引，替换同一个 key 的旧记录
+func (s *Store) link(loc *location) {
+	s.unlink(loc.key)
+	s.index[loc.key] = s.order.PushBack(loc)
+	s.live += loc.size
+}
+
+// 从索引中删除 key，不存在时返回 false
+func (s *Store) unlink(key string) bool {
+	ele, ok := s.index[key]
+	if !ok {
+		return false
+	}
+	s.order.Remove(ele)
+	delete(s.index, key)
+	s.live -= ele.Value.(*location).size
+	return true
+}
+
+// 追加一条记录
+func (s *Store) append(buf []byte) (int64, error) {
+	offset := s.size
+	n, err := s.f.Write(buf)
+	s.size += int64(n)
+	if err != nil {
+		//写入失败，截断写了一半的记录
+		s.f.Truncate(offset)
+		s.f.Seek(offset, io.SeekStart)
+		s.size = offset
+		return 0, err
+	}
+	return offset, nil
+}
+
+// 写入 key 对应的值，expire 为零值表示永不过期
+func (s *Store) Put(key string, value []byte, expire time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var exp int64
+	if !expire.IsZero() {
+		exp = expire.UnixNano()
+	}
+	buf := encodeRecord(0, exp, key, value)
+	offset, err := s.append(buf)
+	if err != nil {
+		return err
+	}
+	s.link(&location{key: key, offset: offset, size: int64(len(buf)), expire: exp})
+	//超过上限时淘汰最早写入的记录
+	for s.maxBytes > 0 && s.live > s.maxBytes && s.order.Len() > 0 {
+		if err = s.delete(s.order.Front().Value.(*location).key); err != nil {
+			return err
+		}
+	}
+	return s.maybeCompact()
+}
+
+// 查找 key，已经过期的记录当作不存在
+func (s *Store) Get(key string) ([]byte, time.Time, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ele, ok := s.index[key]
+	if !ok {
+		return nil, time.Time{}, false, nil
+	}
+	loc := ele.Value.(*location)
+	var expire time.Time
+	if loc.expire != 0 {
+		expire = time.Unix(0, loc.expire)
+		if !time.Now().Before(expire) {
+			s.unlink(key)
+			return nil, time.Time{}, false, nil
+		}
+	}
+	buf := make([]byte, loc.size)
+	if _, err := s.f.ReadAt(buf, loc.offset); err != nil {
+		return nil, time.Time{}, false, err
+	}
+	_, _, _, value, _, err := readRecord(bytes.NewReader(buf))
+	if err != nil {
+		s.unlink(key)
+		return nil, time.Time{}, false, fmt.Errorf("%w: key %s at offset %d", err, key, loc.offset)
+	}
+	return value, expire, true, nil
+}
+
+// 删除 key，不存在时什么都不做
+func (s *Store) Delete(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.index[key]; !ok {
+		return nil
+	}
+	if err := s.delete(key); err != nil {
+		return err
+	}
+	return s.maybeCompact()
+}
+
+// 追加删除标记，重新打开时 key 不会复活
+func (s *Store) delete(key string) error {
+	if _, err := s.append(encodeRecord(flagDeleted, 0, key, nil)); err != nil {
+		return err
+	}
+	s.unlink(key)
+	return nil
+}
+
+// 有效记录的个数
+func (s *Store) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.order.Len()
+}
+
+// 有效记录占用的字节数
+func (s *Store) Bytes() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.live
+}
+
+// 数据文件的大小，包括垃圾
+func (s *Store) FileSize() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.size
+}
+
+// 垃圾超过一半时在后台压缩，调用者持有 s.mutex
+// Put 和 Delete 只追加记录，不会等待文件重写和 fsync
+func (s *Store) maybeCompact() error {
+	if s.compacting || s.size < s.compactMinBytes || s.size-s.live <= s.live {
+		return nil
+	}
+	s.compacting = true
+	go func() {
+		err := s.compact()
+		s.mutex.Lock()
+		s.compacting = false
+		s.mutex.Unlock()
+		if err != nil && !errors.Is(err, os.ErrClosed) {
+			log.Printf("[disk] failed to compact %s: %v", s.dir, err)
+		}
+	}()
+	return nil
+}
+
+// 立即压缩数据文件，等待压缩完成
+func (s *Store) Compact() error {
+	return s.compact()
+}
+
+// 按写入顺序把有效记录复制到新文件，再替换原来的文件
+// 过期的记录在这里被丢弃
+// 复制和 fsync 不持有 s.mutex，期间追加的记录在最后持有锁时复制到新文件末尾
+func (s *Store) compact() error {
+	s.compactMutex.Lock()
+	defer s.compactMutex.Unlock()
+
+	//记录当前的有效记录和文件大小，之后的写入只会追加到 base 之后
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return os.ErrClosed
+	}
+	old := s.f
+	base := s.size
+	snapshot := make([]location, 0, s.order.Len())
+	for ele := s.order.Front(); ele != nil; ele = ele.Next() {
+		snapshot = append(snapshot, *ele.Value.(*location))
+	}
+	s.mutex.Unlock()
+
+	path := filepath.Join(s.dir, compactFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	w := bufio.NewWriter(f)
+	now := time.Now().UnixNano()
+	var offset int64
+	//旧文件中的位置到新文件中的位置
+	moved := make(map[int64]int64, len(snapshot))
+	for _, loc := range snapshot {
+		if loc.expire != 0 && loc.expire <= now {
+			continue
+		}
+		buf := make([]byte, loc.size)
+		if _, err = old.ReadAt(buf, loc.offset); err == nil {
+			_, err = w.Write(buf)
+		}
+		if err != nil {
+			return fail(err)
+		}
+		moved[loc.offset] = offset
+		offset += loc.size
+	}
+	if err = w.Flush(); err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return fail(os.ErrClosed)
+	}
+	//复制期间追加的记录，通常很少，原样放到新文件末尾
+	tail := offset
+	if _, err = io.Copy(w, io.NewSectionReader(old, base, s.size-base)); err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(path, filepath.Join(s.dir, dataFile))
+	}
+	if err != nil {
+		return fail(err)
+	}
+	locs := make([]*location, 0, s.order.Len())
+	for ele := s.order.Front(); ele != nil; ele = ele.Next() {
+		loc := ele.Value.(*location)
+		if loc.offset >= base {
+			loc.offset = tail + loc.offset - base
+		} else if n, ok := moved[loc.offset]; ok {
+			loc.offset = n
+		} else {
+			//已经过期，没有复制
+			continue
+		}
+		locs = append(locs, loc)
+	}
+	old.Close()
+	s.f = f
+	s.size = tail + s.size - base
+	s.live = 0
+	s.index = make(map[string]*list.Element, len(locs))
+	s.order.Init()
+	for _, loc := range locs {
+		s.link(loc)
+	}
+	_, err = s.f.Seek(s.size, io.SeekStart)
+	return err
+}
+
+// 关闭数据文件，等待正在进行的压缩结束
+func (s *Store) Close() error {
+	s.compactMutex.Lock()
+	defer s.compactMutex.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	return s.f.Close()
+}
@@ -0,0 +1,161 @@
+package disk
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func mustGet(t *testing.T, s *Store, key, expect string) {
+	t.Helper()
+	v, _, ok, err := s.Get(key)
+	if err != nil || !ok || string(v) != expect {
+		t.Fatalf("expect %s=%s, got %q %v %v", key, expect, v, ok, err)
+	}
+}
+
+func mustMiss(t *testing.T, s *Store, key string) {
+	t.Helper()
+	if _, _, ok, _ := s.Get(key); ok {
+		t.Fatalf("%s should not exist", key)
+	}
+}
+
+func TestPutGet(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put("k1", []byte("v1"), time.Time{})
+	s.Put("k2", []byte("v2"), time.Time{})
+	s.Put("k1", []byte("v1-new"), time.Time{})
+	s.Put("old", []byte("v"), time.Now().Add(-time.Second))
+	s.Delete("k2")
+	mustGet(t, s, "k1", "v1-new")
+	mustMiss(t, s, "k2")
+	mustMiss(t, s, "old")
+	s.Close()
+
+	//重新打开之后索引从文件中恢复，删除的 key 不会复活
+	s, err = Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	mustGet(t, s, "k1", "v1-new")
+	mustMiss(t, s, "k2")
+	if s.Len() != 1 {
+		t.Fatalf("expect 1 live record, got %d", s.Len())
+	}
+}
+
+// 进程在写入过程中崩溃，末尾不完整的记录被截断
+func TestTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir, 0)
+	s.Put("k1", []byte("v1"), time.Time{})
+	s.Put("k2", []byte("v2"), time.Time{})
+	size := s.FileSize()
+	s.Close()
+
+	path := filepath.Join(dir, dataFile)
+	os.Truncate(path, size-1)
+	s, err := Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustGet(t, s, "k1", "v1")
+	mustMiss(t, s, "k2")
+	//截断之后可以继续写入
+	s.Put("k3", []byte("v3"), time.Time{})
+	s.Close()
+	s, _ = Open(dir, 0)
+	defer s.Close()
+	mustGet(t, s, "k3", "v3")
+}
+
+func TestMaxBytes(t *testing.T) {
+	record := int64(headerSize + len("k0") + len("v0"))
+	s, _ := Open(t.TempDir(), 3*record)
+	defer s.Close()
+	for i := 0; i < 5; i++ {
+		s.Put("k"+strconv.Itoa(i), []byte("v"+strconv.Itoa(i)), time.Time{})
+	}
+	//淘汰最早写入的记录
+	mustMiss(t, s, "k0")
+	mustMiss(t, s, "k1")
+	for i := 2; i < 5; i++ {
+		mustGet(t, s, "k"+strconv.Itoa(i), "v"+strconv.Itoa(i))
+	}
+}
+
+func TestCompact(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir, 0)
+	s.SetCompactMinBytes(1024)
+	for i := 0; i < 100; i++ {
+		s.Put("key", []byte(strconv.Itoa(i)), time.Time{})
+	}
+	s.Put("other", []byte("x"), time.Time{})
+	//反复覆盖产生的垃圾在后台被自动清理
+	for i := 0; s.FileSize() > 2048; i++ {
+		if i == 100 {
+			t.Fatalf("file should be compacted, size %d", s.FileSize())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if s.FileSize() != s.Bytes() {
+		t.Fatalf("compacted file should only contain live records: %d != %d", s.FileSize(), s.Bytes())
+	}
+	mustGet(t, s, "key", "99")
+	s.Close()
+
+	s, _ = Open(dir, 0)
+	defer s.Close()
+	mustGet(t, s, "key", "99")
+	mustGet(t, s, "other", "x")
+}
+
+// 压缩期间的写入和删除不会丢失，重新打开后仍然有效
+func TestCompactConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir, 0)
+	s.SetCompactMinBytes(1 << 40)
+	for i := 0; i < 1000; i++ {
+		s.Put("old"+strconv.Itoa(i), []byte(strconv.Itoa(i)), time.Time{})
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			s.Put("new"+strconv.Itoa(i), []byte(strconv.Itoa(i)), time.Time{})
+			s.Delete("old" + strconv.Itoa(i))
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		if err := s.Compact(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, _ = Open(dir, 0)
+	defer s.Close()
+	if s.Len() != 1000 {
+		t.Fatalf("expect 1000 records, got %d", s.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		mustMiss(t, s, "old"+strconv.Itoa(i))
+		mustGet(t, s, "new"+strconv.Itoa(i), strconv.Itoa(i))
+	}
+}
@@ -0,0 +1,83 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+var (
+	//数据源中不存在这个 key，Getter 应该返回 ErrNotFound 或者包装了它的错误
+	ErrNotFound = errors.New("geecache: key not found")
+	//远程节点无法访问或者返回了意料之外的结果
+	ErrPeerUnavailable = errors.New("geecache: peer unavailable")
+	//Getter 返回了 ErrNotFound 以外的错误
+	ErrLoaderFailed = errors.New("geecache: loader failed")
+	//key 是空字符串
+	ErrEmptyKey = errors.New("geecache: key is required")
+	//值超过了 Group 或者 HTTPPool 设置的大小上限
+	ErrValueTooLarge = errors.New("geecache: value too large")
+	//快照格式不正确、版本不支持或者校验和不一致
+	ErrBadSnapshot = errors.New("geecache: bad snapshot")
+	//Group 已经被 DestroyGroup 或 ReplaceGroup 销毁
+	ErrGroupClosed = errors.New("geecache: group closed")
+	//远程节点拒绝了请求，证书或者共享密钥配置不一致
+	ErrUnauthorized = errors.New("geecache: unauthorized")
+)
+
+// 包装 Getter 返回的错误，errors.Is(err, ErrLoaderFailed) 为 true，
+// 同时可以通过 errors.Unwrap 拿到原始错误
+type loaderError struct {
+	err error
+}
+
+func (e *loaderError) Error() string {
+	return ErrLoaderFailed.Error() + ": " + e.err.Error()
+}
+
+func (e *loaderError) Unwrap() error {
+	return e.err
+}
+
+func (e *loaderError) Is(target error) bool {
+	return target == ErrLoaderFailed
+}
+
+// 包装访问远程节点时的错误，errors.Is(err, ErrPeerUnavailable) 为 true
+type peerError struct {
+	err error
+}
+
+func (e *peerError) Error() string {
+	return ErrPeerUnavailable.Error() + ": " + e.err.Error()
+}
+
+func (e *peerError) Unwrap() error {
+	return e.err
+}
+
+func (e *peerError) Is(target error) bool {
+	return target == ErrPeerUnavailable
+}
+
+// 把 Group.Get 返回的错误转换成 HTTP 状态码
+func HTTPStatus(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, ErrEmptyKey):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrValueTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, ErrPeerUnavailable):
+		return http.StatusBadGateway
+	case errors.Is(err, ErrGroupClosed):
+		//请求方会把它当作节点不可用，由自己回源
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
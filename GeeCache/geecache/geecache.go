@@ -1,12 +1,23 @@
 package geecache
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"geecache/arc"
+	"geecache/disk"
 	pb "geecache/geecachepb"
+	"geecache/lfu"
+	"geecache/lru"
+	"geecache/policy"
 	"geecache/singleflight"
+	"geecache/tinylfu"
+	"geecache/twoqueue"
 	"log"
+	"math/rand"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Getter interface {
@@ -18,10 +29,178 @@ type Group struct {
 	name      string
 	getter    Getter
 	mainCache cache
-	peers     PeerPicker
-	loader    *singleflight.Group //确保key对应的请求只被调用一次
+	//保存从远程节点获取的热点 key 的副本，避免热点 key 每次都访问同一个远程节点
+	hotCache cache
+	//hotCache 占 NewGroup 传入内存的比例，0 表示不启用
+	hotRatio float64
+	//从远程节点获取的值以这个概率写入 hotCache
+	hotSampleRate float64
+	//记录数据源中不存在的 key，避免反复回源
+	negCache cache
+	//不存在的 key 被记住的时间，0 表示不启用
+	negativeTTL time.Duration
+	//节点池，Cache.RegisterPeers 可能在 Group 使用期间设置，通过 getPeers 读取
+	peersMu sync.RWMutex
+	peers   PeerPicker
+	loader  *singleflight.Group //确保key对应的请求只被调用一次
+	//缓存记录的默认存活时间，0 表示永不过期
+	ttl time.Duration
+	//后台清理过期记录的间隔
+	sweepInterval time.Duration
+	//关闭后台清理协程
+	stop chan struct{}
+	//统计信息
+	stats groupStats
+	//第二级缓存，保存 mainCache 因为容量不足淘汰的记录，nil 表示不启用
+	diskTier *disk.Store
+	//mainCache 中的值和节点之间传输的值使用的压缩算法，nil 表示不压缩
+	compressor Compressor
+	//值的大小上限，0 表示不限制
+	maxValueSize int64
+	//DestroyGroup 或 ReplaceGroup 之后为 true，之后的读写返回 ErrGroupClosed
+	closed atomic.Bool
+	//每个 key 保存在几个节点上，小于 2 表示只保存在主节点上
+	replicas int
+	//一次回源（远程节点或者 Getter）的超时时间，0 表示不限制
+	loadTimeout time.Duration
 }
 
+// 创建 Group 时的可选配置
+type GroupOption func(*Group)
+
+// 设置缓存记录的默认存活时间
+func WithTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.ttl = ttl
+	}
+}
+
+// 设置后台清理过期记录的间隔，不设置时使用 defaultSweepInterval
+func WithSweepInterval(d time.Duration) GroupOption {
+	return func(g *Group) {
+		g.sweepInterval = d
+	}
+}
+
+// 设置记录被淘汰（容量不足或过期）时的回调函数
+func WithOnEvicted(f func(key string, value ByteView, reason policy.EvictReason)) GroupOption {
+	return func(g *Group) {
+		g.mainCache.onEvicted = f
+	}
+}
+
+// 设置 mainCache 的淘汰策略，默认是 LRU
+func WithEvictionPolicy(f policy.Factory) GroupOption {
+	return func(g *Group) {
+		g.mainCache.newPolicy = f
+	}
+}
+
+// 设置数据源中不存在的 key 被记住的时间，期间的 Get 直接返回 ErrNotFound
+func WithNegativeTTL(d time.Duration) GroupOption {
+	return func(g *Group) {
+		g.negativeTTL = d
+	}
+}
+
+// 设置 mainCache 和 hotCache 的分片个数，每个分片单独加锁
+func WithShards(n int) GroupOption {
+	return func(g *Group) {
+		g.mainCache.nshards = n
+		g.hotCache.nshards = n
+	}
+}
+
+// 设置 hotCache 占总内存的比例，0 表示不启用 hotCache
+func WithHotCache(ratio float64) GroupOption {
+	return func(g *Group) {
+		g.hotRatio = ratio
+	}
+}
+
+// 设置从远程节点获取的值写入 hotCache 的概率
+func WithHotCacheSampling(rate float64) GroupOption {
+	return func(g *Group) {
+		g.hotSampleRate = rate
+	}
+}
+
+// 设置第二级缓存：mainCache 因为容量不足淘汰的记录写入 store，
+// 缓存未命中时先查找 store，再访问远程节点或者 Getter
+func WithDiskTier(store *disk.Store) GroupOption {
+	return func(g *Group) {
+		g.diskTier = store
+	}
+}
+
+// 设置压缩算法，mainCache 中保存压缩后的值，返回给调用者之前解压
+// 用 CPU 换内存，适合可压缩的大值
+func WithCompression(c Compressor) GroupOption {
+	return func(g *Group) {
+		g.compressor = c
+	}
+}
+
+// 设置值的大小上限，超过上限的值不会写入缓存，远程节点返回的值超过上限时当作错误
+func WithMaxValueSize(n int64) GroupOption {
+	return func(g *Group) {
+		g.maxValueSize = n
+	}
+}
+
+// 每个 key 保存在 n 个节点上：回源的节点把值写入其他副本，Set 和 Remove 作用于所有副本，
+// 主节点不可用时从下一个副本读取，而不是回源。节点池需要实现 ReplicaPicker
+func WithReplicas(n int) GroupOption {
+	return func(g *Group) {
+		g.replicas = n
+	}
+}
+
+// 设置一次回源的超时时间，默认是 defaultLoadTimeout，0 表示不限制
+// 并发请求同一个 key 的调用者共享一次回源，它不受任何一个调用者的 ctx 取消的影响，只受这个超时限制
+func WithLoadTimeout(d time.Duration) GroupOption {
+	return func(g *Group) {
+		g.loadTimeout = d
+	}
+}
+
+// 内置的淘汰策略，可以按名字选择
+var policies = map[string]policy.Factory{
+	"lru":     lru.NewPolicy,
+	"lfu":     lfu.NewPolicy,
+	"arc":     arc.NewPolicy,
+	"2q":      twoqueue.NewPolicy,
+	"tinylfu": tinylfu.NewPolicy,
+}
+
+// 根据名字查找内置的淘汰策略
+func PolicyByName(name string) (policy.Factory, bool) {
+	f, ok := policies[name]
+	return f, ok
+}
+
+const (
+	defaultSweepInterval = time.Minute
+	//共享的回源最多进行这么久
+	defaultLoadTimeout = 30 * time.Second
+	//negCache 只保存 key，给它固定的内存上限
+	negativeCacheBytes = 1 << 20
+	//和 groupcache 一样，默认把 1/8 的内存分给 hotCache
+	defaultHotCacheRatio = 1.0 / 8
+	//默认 10% 的远程值会写入 hotCache
+	defaultHotSampleRate = 0.1
+)
+
+// 选择 Group 中的某个 cache，用于查看统计信息
+type CacheType int
+
+const (
+	//保存本节点负责的 key
+	MainCache CacheType = iota + 1
+	//保存远程节点负责的热点 key
+	HotCache
+)
+
 // 函数类型GetterFunc
 type GetterFunc func(key string) ([]byte, error)
 
@@ -30,131 +209,538 @@ func (g GetterFunc) Get(key string) ([]byte, error) {
 	return g(key)
 }
 
-var (
-	mu     sync.RWMutex
-	groups = make(map[string]*Group)
-)
+// 可以为每个 key 单独指定存活时间的 Getter
+// 返回的 ttl 大于 0 时覆盖 Group 的默认存活时间
+type TTLGetter interface {
+	GetWithTTL(key string) ([]byte, time.Duration, error)
+}
+
+// 函数类型TTLGetterFunc，同时实现了 Getter 和 TTLGetter
+type TTLGetterFunc func(key string) ([]byte, time.Duration, error)
+
+func (f TTLGetterFunc) Get(key string) ([]byte, error) {
+	b, _, err := f(key)
+	return b, err
+}
+
+func (f TTLGetterFunc) GetWithTTL(key string) ([]byte, time.Duration, error) {
+	return f(key)
+}
+
+// 能够响应 ctx 取消和超时的 Getter，ctx 在回源超时（WithLoadTimeout）之后取消
+// 传给 NewGroup 的 Getter 如果同时实现了 ContextGetter，会优先调用 GetContext
+type ContextGetter interface {
+	GetContext(ctx context.Context, key string) ([]byte, error)
+}
+
+// 函数类型ContextGetterFunc，同时实现了 Getter 和 ContextGetter
+type ContextGetterFunc func(ctx context.Context, key string) ([]byte, error)
+
+func (f ContextGetterFunc) Get(key string) ([]byte, error) {
+	return f(context.Background(), key)
+}
+
+func (f ContextGetterFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+// 同时需要 ctx 和单独指定存活时间的 Getter，优先于 ContextGetter 和 TTLGetter
+// 只实现了 ContextGetter 和 TTLGetter 的 Getter 调用 GetContext，使用 Group 的默认存活时间
+type ContextTTLGetter interface {
+	GetContextWithTTL(ctx context.Context, key string) ([]byte, time.Duration, error)
+}
+
+// 函数类型ContextTTLGetterFunc，同时实现了 Getter、TTLGetter、ContextGetter 和 ContextTTLGetter
+type ContextTTLGetterFunc func(ctx context.Context, key string) ([]byte, time.Duration, error)
+
+func (f ContextTTLGetterFunc) Get(key string) ([]byte, error) {
+	b, _, err := f(context.Background(), key)
+	return b, err
+}
+
+func (f ContextTTLGetterFunc) GetWithTTL(key string) ([]byte, time.Duration, error) {
+	return f(context.Background(), key)
+}
+
+func (f ContextTTLGetterFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	b, _, err := f(ctx, key)
+	return b, err
+}
 
-// 一个Group可以认为是一个缓存的命名空间
-func NewGroup(name string, bytes int64, getter Getter) *Group {
+func (f ContextTTLGetterFunc) GetContextWithTTL(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	return f(ctx, key)
+}
+
+func newGroup(name string, bytes int64, getter Getter, opts ...GroupOption) *Group {
 	if getter == nil {
 		panic("nil Getter")
 	}
-	mu.Lock()
-	defer mu.Unlock()
 	g := &Group{
-		name:   name,
-		getter: getter,
-		mainCache: cache{
-			cacheBytes: bytes,
-		},
-		loader: &singleflight.Group{},
-	}
-	groups[name] = g
+		name:          name,
+		getter:        getter,
+		loader:        &singleflight.Group{},
+		hotRatio:      defaultHotCacheRatio,
+		hotSampleRate: defaultHotSampleRate,
+		loadTimeout:   defaultLoadTimeout,
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	g.mainCache.cacheBytes, g.hotCache.cacheBytes = g.splitBytes(bytes)
+	g.negCache.cacheBytes = negativeCacheBytes
+	if g.diskTier != nil {
+		g.spillToDisk()
+	}
+	//只有可能产生过期记录时才启动后台清理
+	_, ttlGetter := getter.(TTLGetter)
+	_, ctxTTLGetter := getter.(ContextTTLGetter)
+	if ttlGetter || ctxTTLGetter || g.ttl > 0 || g.negativeTTL > 0 {
+		if g.sweepInterval <= 0 {
+			g.sweepInterval = defaultSweepInterval
+		}
+		g.stop = make(chan struct{})
+		go g.sweep()
+	}
 	return g
 }
 
-func GetGroup(name string) *Group {
-	//只读锁
-	mu.RLock()
-	g := groups[name]
-	mu.RUnlock()
-	return g
+// hotCache 的内存从总内存中划分出来
+func (g *Group) splitBytes(bytes int64) (mainBytes, hotBytes int64) {
+	hotBytes = int64(float64(bytes) * g.hotRatio)
+	return bytes - hotBytes, hotBytes
+}
+
+// 销毁之后停止后台清理，释放缓存占用的内存
+func (g *Group) close() {
+	if !g.closed.CompareAndSwap(false, true) {
+		return
+	}
+	if g.stop != nil {
+		close(g.stop)
+	}
+	g.mainCache.purge()
+	g.hotCache.purge()
+	g.negCache.purge()
+}
+
+// 在运行时修改 Group 的总内存，按创建时的比例分给 mainCache 和 hotCache
+// 缩小时超出上限的记录按淘汰策略移除，启用了 WithDiskTier 时写入磁盘
+func (g *Group) Resize(bytes int64) error {
+	if bytes < 0 {
+		return fmt.Errorf("geecache: negative cache size %d", bytes)
+	}
+	if g.closed.Load() {
+		return ErrGroupClosed
+	}
+	mainBytes, hotBytes := g.splitBytes(bytes)
+	if !g.mainCache.resize(mainBytes) || !g.hotCache.resize(hotBytes) {
+		return fmt.Errorf("geecache: eviction policy of group %s does not support resize", g.name)
+	}
+	return nil
+}
+
+// 当前的总内存上限
+func (g *Group) CacheBytes() int64 {
+	return g.mainCache.capacity() + g.hotCache.capacity()
+}
+
+// 返回 Group 的名字
+func (g *Group) Name() string {
+	return g.name
 }
 
 func (g *Group) Get(key string) (ByteView, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// 和 Get 一样，ctx 取消或超时时提前返回
+// 远程节点的请求和 ContextGetter 收到的 ctx 带有 ctx 中的值，但是只在 WithLoadTimeout 设置的时间之后取消
+func (g *Group) GetContext(ctx context.Context, key string) (ByteView, error) {
+	v, err := g.getContext(ctx, key)
+	if err != nil {
+		return ByteView{}, err
+	}
+	return g.decode(v)
+}
+
+// 返回的值可能是压缩后的，服务端直接发送给远程节点，不需要解压再压缩
+func (g *Group) getContext(ctx context.Context, key string) (ByteView, error) {
 	//如果查找的key是空string
 	if key == "" {
-		return ByteView{}, fmt.Errorf("key is required")
+		return ByteView{}, ErrEmptyKey
+	}
+	if g.closed.Load() {
+		return ByteView{}, ErrGroupClosed
 	}
+	g.stats.gets.Add(1)
 
 	//从 mainCache 中查找缓存，如果存在则返回缓存值。
 	v, ok := g.mainCache.get(key)
 	if ok {
 		log.Println("[GeeCache] hit")
+		g.stats.hits.Add(1)
+		return v, nil
+	}
+	//远程节点负责的热点 key 可能在 hotCache 中有副本
+	if g.hotRatio > 0 {
+		if v, ok := g.hotCache.get(key); ok {
+			log.Println("[GeeCache] hot cache hit")
+			g.stats.hits.Add(1)
+			return v, nil
+		}
+	}
+	//最近确认过不存在的 key
+	if g.negativeTTL > 0 {
+		if _, ok := g.negCache.get(key); ok {
+			g.stats.negativeHits.Add(1)
+			return ByteView{}, ErrNotFound
+		}
+	}
+	//第二级缓存
+	if v, ok := g.getFromDisk(key); ok {
 		return v, nil
 	}
+	g.stats.misses.Add(1)
 	//缓存不存在，则调用 load 方法
 	//fmt.Println(key, " not find in cache")
-	return g.load(key)
+	return g.load(ctx, key)
 }
 
-func (g *Group) load(key string) (ByteView, error) {
+func (g *Group) load(ctx context.Context, key string) (ByteView, error) {
 	//每个密钥只获取一次（本地或远程）
 	// 不管并发调用者的数量。
-	viewi, err := g.loader.Do(key, func() (interface{}, error) {
-		if g.peers != nil {
+	//每个调用者只在自己的 ctx 取消时放弃等待，回源本身使用 loadContext，
+	//发起回源的调用者取消时其他调用者不受影响
+	viewi, err := g.loader.DoContext(ctx, key, func() (interface{}, error) {
+		ctx, cancel := g.loadContext(ctx)
+		defer cancel()
+		peers := g.loadPeers(ctx)
+		if rp, ok := peers.(ReplicaPicker); ok && g.replicas > 1 {
+			return g.loadReplicated(ctx, rp, key)
+		}
+		if peers != nil {
 			//使用PickPeer方法选择节点，若非本机节点，则从远程获取
-			if peer, ok := g.peers.PickPeer(key); ok {
-				value, err := g.getFromPeer(peer, key)
+			if peer, ok := peers.PickPeer(key); ok {
+				value, err := g.getFromPeer(ctx, peer, key)
 				if err == nil {
+					g.stats.peerLoads.Add(1)
+					//只抽样一部分写入 hotCache，真正的热点 key 很快就会被抽中
+					if g.hotRatio > 0 && rand.Float64() < g.hotSampleRate {
+						g.hotCache.add(key, value, value.e)
+					}
 					return value, nil
 				}
+				//远程节点确认 key 不存在，不需要再回源
+				if errors.Is(err, ErrNotFound) {
+					g.rememberNotFound(key)
+					return nil, err
+				}
+				g.stats.peerErrors.Add(1)
 				log.Println("[GeeCache] Failed to get from peer", err)
 			}
 		}
+		//回源已经超时，不再调用 Getter
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		//若是本机节点或者失败，回退至getLocally
-		return g.getLocally(key)
+		return g.getLocally(ctx, key)
 	})
 
 	if err == nil {
 		return viewi.(ByteView), nil
 	}
 
-	return ByteView{}, nil
+	return ByteView{}, err
+
+}
+
+// 共享的回源使用的 ctx：保留调用者 ctx 中的值，但是不继承它的取消和截止时间，只受 loadTimeout 限制
+func (g *Group) loadContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx = detachedContext{ctx}
+	if g.loadTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, g.loadTimeout)
+}
+
+type localLoadKey struct{}
+
+// 标记 ctx，缓存未命中时不访问远程节点，直接在本地回源
+func withLocalLoad(ctx context.Context) context.Context {
+	return context.WithValue(ctx, localLoadKey{}, true)
+}
+
+// 回源时使用的节点池，ctx 被 withLocalLoad 标记过时返回 nil
+func (g *Group) loadPeers(ctx context.Context) PeerPicker {
+	if local, _ := ctx.Value(localLoadKey{}).(bool); local {
+		return nil
+	}
+	return g.getPeers()
+}
+
+// 只保留 parent 中的值，永远不会被取消，Go 1.21 之后可以换成 context.WithoutCancel
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
 
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
 }
 
-func (g *Group) getLocally(key string) (ByteView, error) {
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, error) {
 	//调用用户回调函数 g.getter.Get() 获取源数据
-	bytes, err := g.getter.Get(key)
+	var (
+		bytes []byte
+		ttl   = g.ttl
+		err   error
+	)
+	//ctx 优先于 TTL，同时实现两个接口时调用 GetContext
+	switch getter := g.getter.(type) {
+	case ContextTTLGetter:
+		var d time.Duration
+		bytes, d, err = getter.GetContextWithTTL(ctx, key)
+		if d > 0 {
+			ttl = d
+		}
+	case ContextGetter:
+		bytes, err = getter.GetContext(ctx, key)
+	case TTLGetter:
+		var d time.Duration
+		bytes, d, err = getter.GetWithTTL(key)
+		if d > 0 {
+			ttl = d
+		}
+	default:
+		bytes, err = g.getter.Get(key)
+	}
 	if err != nil {
-		return ByteView{}, err
+		g.stats.localLoadErrs.Add(1)
+		if errors.Is(err, ErrNotFound) {
+			g.rememberNotFound(key)
+			return ByteView{}, err
+		}
+		return ByteView{}, &loaderError{err: err}
 	}
+	g.stats.localLoads.Add(1)
 
-	value := ByteView{b: cloneBytes(bytes)}
-	//fmt.Println(bytes, value)
+	var expire time.Time
+	if ttl > 0 {
+		expire = time.Now().Add(ttl)
+	}
+	value := ByteView{b: cloneBytes(bytes), e: expire}
 	//将源数据添加到缓存 mainCache
-	err = g.populateCache(key, value)
-	if err != nil {
-		fmt.Println("populate failed")
+	//写入失败不影响这次返回的值，只是下次还需要回源
+	if err = g.populateCache(key, value, expire); err != nil {
+		log.Printf("[GeeCache] failed to populate %s: %v", key, err)
 	}
-	//fmt.Println(err)
 	return value, nil
 }
 
 // 填充到mainCache中去
-func (g *Group) populateCache(key string, value ByteView) error {
-	err := g.mainCache.add(key, value)
-	//fmt.Println("jianchashifou zhendde")
-	//v, err1 := g.Get(key)
-	if err != nil {
-		fmt.Println("add failed")
-		return errors.New("add failed")
+func (g *Group) populateCache(key string, value ByteView, expire time.Time) error {
+	//已经销毁的 Group 不再占用内存
+	if g.closed.Load() {
+		return nil
+	}
+	//压缩过的值在第一次写入时已经检查过
+	if !value.z && g.maxValueSize > 0 && int64(value.Len()) > g.maxValueSize {
+		return fmt.Errorf("%w: %s has %d bytes", ErrValueTooLarge, key, value.Len())
+	}
+	if err := g.mainCache.add(key, g.encode(value), expire); err != nil {
+		return err
+	}
+	//key 已经存在了
+	g.negCache.remove(key)
+	//同一个 key 只保存在一级缓存中
+	if g.diskTier != nil {
+		if err := g.diskTier.Delete(key); err != nil {
+			log.Printf("[GeeCache] failed to delete %s from disk: %v", key, err)
+		}
 	}
 	return nil
 }
 
+// 在 negCache 中记住不存在的 key
+func (g *Group) rememberNotFound(key string) {
+	if g.negativeTTL > 0 {
+		g.negCache.add(key, ByteView{}, time.Now().Add(g.negativeTTL))
+	}
+}
+
+// 写入缓存值，key 所属的节点是远程节点时写入远程节点，否则写入本地的 mainCache
+// 使用 Group 的默认存活时间
+func (g *Group) Set(key string, value []byte) error {
+	if key == "" {
+		return ErrEmptyKey
+	}
+	if g.closed.Load() {
+		return ErrGroupClosed
+	}
+	var expire time.Time
+	if g.ttl > 0 {
+		expire = time.Now().Add(g.ttl)
+	}
+	peers := g.getPeers()
+	if rp, ok := peers.(ReplicaPicker); ok && g.replicas > 1 {
+		return g.setReplicas(rp, key, ByteView{b: cloneBytes(value), e: expire})
+	}
+	if peers != nil {
+		if peer, ok := peers.PickPeer(key); ok {
+			req := &pb.SetRequest{
+				Group: g.name,
+				Key:   key,
+				Value: value,
+			}
+			if !expire.IsZero() {
+				req.Expire = expire.UnixNano()
+			}
+			g.hotCache.remove(key)
+			return peer.Set(req, &pb.Response{})
+		}
+	}
+	return g.populateCache(key, ByteView{b: cloneBytes(value)}, expire)
+}
+
+// 删除 key 所属节点上的缓存值
+func (g *Group) Remove(key string) error {
+	if key == "" {
+		return ErrEmptyKey
+	}
+	if g.closed.Load() {
+		return ErrGroupClosed
+	}
+	//本地可能残留旧值，无论 key 属于哪个节点都先删掉
+	g.removeLocally(key)
+	peers := g.getPeers()
+	if rp, ok := peers.(ReplicaPicker); ok && g.replicas > 1 {
+		return g.removeReplicas(rp, key)
+	}
+	if peers != nil {
+		if peer, ok := peers.PickPeer(key); ok {
+			return peer.Remove(&pb.Request{Group: g.name, Key: key}, &pb.Response{})
+		}
+	}
+	return nil
+}
+
+// 让 key 在所有节点上失效，适用于数据源更新后清理各个节点上可能存在的副本
+// PeerPicker 没有实现 PeerLister 时退化为 Remove
+func (g *Group) Invalidate(key string) error {
+	if key == "" {
+		return ErrEmptyKey
+	}
+	if g.closed.Load() {
+		return ErrGroupClosed
+	}
+	lister, ok := g.getPeers().(PeerLister)
+	if !ok {
+		return g.Remove(key)
+	}
+	g.removeLocally(key)
+	var firstErr error
+	for _, peer := range lister.AllPeers() {
+		err := peer.Remove(&pb.Request{Group: g.name, Key: key}, &pb.Response{})
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// 删除本地 mainCache 和 hotCache 中的缓存值
+func (g *Group) removeLocally(key string) bool {
+	g.negCache.remove(key)
+	if g.diskTier != nil {
+		g.diskTier.Delete(key)
+	}
+	hot := g.hotCache.remove(key)
+	return g.mainCache.remove(key) || hot
+}
+
+// 返回某个 cache 的统计信息
+func (g *Group) CacheStats(which CacheType) CacheStats {
+	switch which {
+	case MainCache:
+		return g.mainCache.stats()
+	case HotCache:
+		return g.hotCache.stats()
+	}
+	return CacheStats{}
+}
+
+// 后台协程，定期清理 mainCache 中已经过期的记录
+func (g *Group) sweep() {
+	ticker := time.NewTicker(g.sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			n := g.mainCache.removeExpired(now) + g.hotCache.removeExpired(now) + g.negCache.removeExpired(now)
+			if n > 0 {
+				log.Printf("[GeeCache] group %s swept %d expired keys", g.name, n)
+			}
+		case <-g.stop:
+			return
+		}
+	}
+}
+
 // 将实现了 PeerPicker 接口的 HTTPPool 注入到 Group 中
 func (g *Group) RegisterPeers(peers PeerPicker) {
-	if g.peers != nil {
+	if !g.setPeers(peers) {
 		panic("RegisterPeerPicker called more than once")
 	}
+}
+
+// 当前的节点池，没有注册时返回 nil
+func (g *Group) getPeers() PeerPicker {
+	g.peersMu.RLock()
+	defer g.peersMu.RUnlock()
+	return g.peers
+}
+
+// 还没有节点池时设置为 peers，已经有节点池时返回 false
+func (g *Group) setPeers(peers PeerPicker) bool {
+	g.peersMu.Lock()
+	defer g.peersMu.Unlock()
+	if g.peers != nil {
+		return false
+	}
 	g.peers = peers
+	return true
 }
 
 // 使用实现了 PeerGetter 接口的 httpGetter 从访问远程节点，获取缓存值
-func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
+// 实现了 ContextPeerGetter 的节点会收到 ctx
+func (g *Group) getFromPeer(ctx context.Context, peer PeerGetter, key string) (ByteView, error) {
 	req := &pb.Request{
 		Group: g.name,
 		Key:   key,
 	}
 	//bytes, err := peer.Get(g.name, key)
 	res := &pb.Response{}
-	err := peer.Get(req, res)
+	var err error
+	if cp, ok := peer.(ContextPeerGetter); ok {
+		err = cp.GetContext(ctx, req, res)
+	} else {
+		err = peer.Get(req, res)
+	}
 	if err != nil {
 		return ByteView{}, err
 	}
 	//return ByteView{b: bytes}, nil
-	return ByteView{b: res.Value}, nil
+	b, err := decodeWire(res.GetEncoding(), res.GetValue(), g.maxValueSize)
+	if err != nil {
+		return ByteView{}, err
+	}
+	var expire time.Time
+	if res.GetExpire() != 0 {
+		expire = time.Unix(0, res.GetExpire())
+	}
+	return ByteView{b: b, e: expire}, nil
 }
@@ -1,10 +1,38 @@
 package geecache
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"geecache/consistenthash"
+	"geecache/disk"
+	pb "geecache/geecachepb"
+	"geecache/policy"
+	"github.com/golang/protobuf/proto"
+	"io/ioutil"
 	"log"
+	"math/big"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestGetter(t *testing.T) {
@@ -35,7 +63,8 @@ var db = map[string]string{
 
 func TestGet(t *testing.T) {
 	loadCounts := make(map[string]int, len(db))
-	gee := NewGroup("scores", 2<<10, GetterFunc(
+	//使用自己的 Cache，重复运行测试时不会和已经注册的同名 Group 冲突
+	gee := New().NewGroup("scores", 2<<10, GetterFunc(
 		func(key string) ([]byte, error) {
 			log.Println("[SlowDB] search key", key)
 			//从数据源中查找
@@ -70,3 +99,1746 @@ func TestGet(t *testing.T) {
 	}
 
 }
+
+func TestTTL(t *testing.T) {
+	c := New()
+	var (
+		mutex   sync.Mutex
+		loads   int
+		evicted = make(chan policy.EvictReason, 1)
+	)
+	gee := c.NewGroup("ttl", 2<<10, TTLGetterFunc(
+		func(key string) ([]byte, time.Duration, error) {
+			mutex.Lock()
+			loads++
+			mutex.Unlock()
+			return []byte(key), 20 * time.Millisecond, nil
+		}),
+		WithSweepInterval(5*time.Millisecond),
+		WithOnEvicted(func(key string, value ByteView, reason policy.EvictReason) {
+			evicted <- reason
+		}))
+
+	if _, err := gee.Get("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gee.Get("Tom"); err != nil || loads != 1 {
+		t.Fatalf("Tom should hit cache before expiring, loads=%d", loads)
+	}
+
+	// 后台清理协程回收过期的记录
+	select {
+	case reason := <-evicted:
+		if reason != policy.EvictExpired {
+			t.Fatalf("expect reason %v, got %v", policy.EvictExpired, reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expired key was not swept")
+	}
+
+	if _, err := gee.Get("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if loads != 2 {
+		t.Fatalf("Tom should be reloaded after expiring, loads=%d", loads)
+	}
+}
+
+func TestEvictionPolicy(t *testing.T) {
+	c := New()
+	for name := range policies {
+		f, _ := PolicyByName(name)
+		loads := 0
+		gee := c.NewGroup("policy-"+name, 2<<10, GetterFunc(
+			func(key string) ([]byte, error) {
+				loads++
+				return []byte(db[key]), nil
+			}), WithEvictionPolicy(f))
+		for k, v := range db {
+			if view, err := gee.Get(k); err != nil || view.String() != v {
+				t.Fatalf("%s: failed to get value of %s", name, k)
+			}
+			if _, err := gee.Get(k); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if loads != len(db) {
+			t.Fatalf("%s: expect %d loads, got %d", name, len(db), loads)
+		}
+	}
+}
+
+// 记录收到的写请求的 PeerGetter，key 以 "remote" 开头时属于远程节点
+type fakePeer struct {
+	mutex   sync.Mutex
+	sets    map[string][]byte
+	removed []string
+	gets    int
+}
+
+func (p *fakePeer) PickPeer(key string) (PeerGetter, bool) {
+	if strings.HasPrefix(key, "remote") {
+		return p, true
+	}
+	return nil, false
+}
+
+func (p *fakePeer) AllPeers() []PeerGetter {
+	return []PeerGetter{p}
+}
+
+func (p *fakePeer) Get(in *pb.Request, out *pb.Response) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.gets++
+	v, ok := p.sets[in.GetKey()]
+	if !ok {
+		return fmt.Errorf("%s not exist", in.GetKey())
+	}
+	out.Value = v
+	return nil
+}
+
+func (p *fakePeer) Set(in *pb.SetRequest, out *pb.Response) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.sets[in.GetKey()] = in.GetValue()
+	return nil
+}
+
+func (p *fakePeer) Remove(in *pb.Request, out *pb.Response) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.sets, in.GetKey())
+	p.removed = append(p.removed, in.GetKey())
+	return nil
+}
+
+func TestSetRemove(t *testing.T) {
+	c := New()
+	loads := 0
+	gee := c.NewGroup("write", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte("db-" + key), nil
+		}))
+	peer := &fakePeer{sets: make(map[string][]byte)}
+	gee.RegisterPeers(peer)
+
+	//本地 key 写入 mainCache，之后的 Get 不再回源
+	if err := gee.Set("Tom", []byte("700")); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := gee.Get("Tom"); err != nil || view.String() != "700" || loads != 0 {
+		t.Fatalf("Set value should be served from cache, got %s, loads=%d", view, loads)
+	}
+	if err := gee.Remove("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if view, _ := gee.Get("Tom"); view.String() != "db-Tom" || loads != 1 {
+		t.Fatalf("removed key should be reloaded, got %s", view)
+	}
+
+	//远程 key 路由到所属节点
+	if err := gee.Set("remote-Jack", []byte("589")); err != nil {
+		t.Fatal(err)
+	}
+	if string(peer.sets["remote-Jack"]) != "589" {
+		t.Fatalf("Set should be routed to the owning peer")
+	}
+	if err := gee.Remove("remote-Jack"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := peer.sets["remote-Jack"]; ok {
+		t.Fatalf("Remove should be routed to the owning peer")
+	}
+
+	//Invalidate 广播到所有节点，包括本地 key
+	if err := gee.Invalidate("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(peer.removed, []string{"remote-Jack", "Tom"}) {
+		t.Fatalf("Invalidate should be broadcast, got %v", peer.removed)
+	}
+	if _, ok := gee.mainCache.get("Tom"); ok {
+		t.Fatalf("Invalidate should remove the local copy")
+	}
+}
+
+func TestHTTPSetRemove(t *testing.T) {
+	c := New()
+	gee := c.NewGroup("http-write", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte("db-" + key), nil
+		}))
+	srv := httptest.NewServer(NewHTTPPool("self", WithCache(c)))
+	defer srv.Close()
+	getter := &httpGetter{baseURL: srv.URL + defaultBasePath}
+
+	req := &pb.SetRequest{Group: "http-write", Key: "Sam", Value: []byte("600")}
+	if err := getter.Set(req, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	res := &pb.Response{}
+	if err := getter.Get(&pb.Request{Group: "http-write", Key: "Sam"}, res); err != nil || string(res.Value) != "600" {
+		t.Fatalf("PUT value should be cached, got %s %v", res.Value, err)
+	}
+	if err := getter.Remove(&pb.Request{Group: "http-write", Key: "Sam"}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gee.mainCache.get("Sam"); ok {
+		t.Fatalf("DELETE should remove the cached value")
+	}
+}
+
+func TestHotCache(t *testing.T) {
+	c := New()
+	gee := c.NewGroup("hot", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}), WithHotCacheSampling(1))
+	peer := &fakePeer{sets: map[string][]byte{"remote-Tom": []byte("630")}}
+	gee.RegisterPeers(peer)
+
+	for i := 0; i < 3; i++ {
+		if view, err := gee.Get("remote-Tom"); err != nil || view.String() != "630" {
+			t.Fatalf("failed to get remote-Tom from peer")
+		}
+	}
+	if peer.gets != 1 {
+		t.Fatalf("hot key should be served from hotCache, peer gets=%d", peer.gets)
+	}
+	hot := gee.CacheStats(HotCache)
+	if hot.Hits != 2 || hot.Items != 1 {
+		t.Fatalf("unexpected hot cache stats %+v", hot)
+	}
+	if main := gee.CacheStats(MainCache); main.Items != 0 {
+		t.Fatalf("remote key should not be stored in mainCache")
+	}
+	if gee.hotCache.cacheBytes != (2<<10)/8 || gee.mainCache.cacheBytes != (2<<10)-(2<<10)/8 {
+		t.Fatalf("hotCache budget should be carved from the group size")
+	}
+
+	//失效后重新访问远程节点
+	if err := gee.Invalidate("remote-Tom"); err != nil {
+		t.Fatal(err)
+	}
+	peer.sets["remote-Tom"] = []byte("700")
+	if view, _ := gee.Get("remote-Tom"); view.String() != "700" || peer.gets != 2 {
+		t.Fatalf("invalidated hot key should be fetched again, got %s", view)
+	}
+}
+
+func TestShards(t *testing.T) {
+	c := &cache{cacheBytes: 1 << 10, nshards: 8}
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		c.add(key, ByteView{b: []byte(key)}, time.Time{})
+	}
+	used := 0
+	for _, s := range c.shards {
+		if s.store.Len() > 0 {
+			used++
+		}
+		if s.store.Bytes() > (1<<10)/8 {
+			t.Fatalf("shard uses more than its share of memory")
+		}
+	}
+	if used < 2 {
+		t.Fatalf("keys should be spread over shards, only %d used", used)
+	}
+	for i := 0; i < 100; i++ {
+		if v, ok := c.get(strconv.Itoa(i)); !ok || v.String() != strconv.Itoa(i) {
+			t.Fatalf("failed to get %d", i)
+		}
+	}
+	if st := c.stats(); st.Items != 100 || st.Hits != 100 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+
+	//内存比分片个数还少时，每个分片至少 1 字节，不会变成不限制
+	small := &cache{cacheBytes: 4, nshards: 8}
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		small.add(key, ByteView{b: []byte(key)}, time.Time{})
+	}
+	if len(small.shards) != 4 || small.stats().Bytes > 4 {
+		t.Fatalf("small cache should use 4 shards and at most 4 bytes, got %d shards %+v", len(small.shards), small.stats())
+	}
+	small.resize(2)
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		small.add(key, ByteView{b: []byte(key)}, time.Time{})
+	}
+	if b := small.stats().Bytes; b > 4 {
+		t.Fatalf("every shard should keep a limit of at least 1 byte after resize, got %d bytes", b)
+	}
+}
+
+// 对比不同分片个数下的并发读写吞吐量
+// go test -run none -bench CacheParallel -cpu 1,2,4,8,16,32
+func BenchmarkCacheParallel(b *testing.B) {
+	const keys = 1 << 12
+	for _, n := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("shards=%d", n), func(b *testing.B) {
+			c := &cache{cacheBytes: 1 << 20, nshards: n}
+			for i := 0; i < keys; i++ {
+				key := strconv.Itoa(i)
+				c.add(key, ByteView{b: []byte(key)}, time.Time{})
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := rand.Intn(keys)
+				for pb.Next() {
+					key := strconv.Itoa(i % keys)
+					//十次读一次写
+					if i%10 == 0 {
+						c.add(key, ByteView{b: []byte(key)}, time.Time{})
+					} else {
+						c.get(key)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	c := New()
+	gee := c.NewGroup("stats", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist", key)
+		}))
+	gee.Get("Tom")
+	gee.Get("Tom")
+	gee.Get("unknown")
+
+	s := gee.Stats()
+	expect := Stats{Gets: 3, Hits: 1, Misses: 2, LocalLoads: 1, LocalLoadErrs: 1, Items: 1, Bytes: int64(len("Tom") + len("630"))}
+	if s != expect {
+		t.Fatalf("expect stats %+v, got %+v", expect, s)
+	}
+
+	//等待同一个 key 的并发请求被合并
+	release := make(chan struct{})
+	slow := c.NewGroup("stats-dedup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		<-release
+		return []byte(key), nil
+	}))
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slow.Get("Tom")
+		}()
+	}
+	for slow.Stats().Dedups != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if s := slow.Stats(); s.Misses != 3 || s.LocalLoads != 1 || s.Dedups != 2 {
+		t.Fatalf("expect 3 misses merged into 1 load, got %+v", s)
+	}
+
+	srv := httptest.NewServer(NewHTTPPool("self", WithCache(c)))
+	defer srv.Close()
+	res, err := http.Get(srv.URL + defaultMetricsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	for _, line := range []string{
+		"# TYPE geecache_gets_total counter",
+		`geecache_gets_total{group="stats"} 3`,
+		`geecache_local_load_errors_total{group="stats"} 1`,
+		`geecache_cache_items{group="stats",cache="main"} 1`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Fatalf("metrics should contain %q", line)
+		}
+	}
+}
+
+func TestGetContext(t *testing.T) {
+	c := New()
+	type ctxKey struct{}
+	gee := c.NewGroup("context", 2<<10, ContextGetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			return []byte(fmt.Sprint(ctx.Value(ctxKey{}))), nil
+		}))
+	ctx := context.WithValue(context.Background(), ctxKey{}, "from-ctx")
+	if view, err := gee.GetContext(ctx, "Tom"); err != nil || view.String() != "from-ctx" {
+		t.Fatalf("ctx should be passed to ContextGetter, got %s %v", view, err)
+	}
+
+	//同时需要 ctx 和存活时间的 Getter 两者都能得到
+	withTTL := c.NewGroup("context-ttl", 2<<10, ContextTTLGetterFunc(
+		func(ctx context.Context, key string) ([]byte, time.Duration, error) {
+			return []byte(fmt.Sprint(ctx.Value(ctxKey{}))), time.Hour, nil
+		}))
+	view, err := withTTL.GetContext(ctx, "Tom")
+	if err != nil || view.String() != "from-ctx" || view.Expire().IsZero() {
+		t.Fatalf("ctx and ttl should both reach ContextTTLGetter, got %s %v expire=%v", view, err, view.Expire())
+	}
+
+	//发起回源的调用者超时不影响同时等待这个 key 的其他调用者
+	release := make(chan struct{})
+	shared := c.NewGroup("context-shared", 2<<10, ContextGetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			select {
+			case <-release:
+				return []byte(key), nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}))
+	short, cancelShort := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelShort()
+	owner := make(chan error)
+	go func() {
+		_, err := shared.GetContext(short, "Jack")
+		owner <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+	waiter := make(chan string)
+	go func() {
+		view, _ := shared.GetContext(context.Background(), "Jack")
+		waiter <- view.String()
+	}()
+	if err := <-owner; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("owner should give up on its own deadline, got %v", err)
+	}
+	close(release)
+	if v := <-waiter; v != "Jack" {
+		t.Fatalf("waiter should get the value after the owner gave up, got %q", v)
+	}
+	//回源本身受 WithLoadTimeout 限制
+	stuck := c.NewGroup("context-timeout", 2<<10, ContextGetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}), WithLoadTimeout(20*time.Millisecond))
+	if _, err := stuck.Get("Tom"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("load should time out, got %v", err)
+	}
+
+	//慢节点在 ctx 超时后放弃，也不会回源
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+	getter := &httpGetter{baseURL: slow.URL + defaultBasePath}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = gee.getFromPeer(ctx, getter, "Sam")
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("slow peer should honor ctx deadline, got %v after %v", err, time.Since(start))
+	}
+}
+
+func TestErrors(t *testing.T) {
+	c := New()
+	loads := make(map[string]int)
+	gee := c.NewGroup("errors", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads[key]++
+			switch key {
+			case "broken":
+				return nil, fmt.Errorf("connection refused")
+			case "Tom":
+				return []byte("630"), nil
+			}
+			return nil, fmt.Errorf("%s not exist: %w", key, ErrNotFound)
+		}), WithNegativeTTL(time.Hour))
+
+	if _, err := gee.Get(""); !errors.Is(err, ErrEmptyKey) {
+		t.Fatalf("expect ErrEmptyKey, got %v", err)
+	}
+	_, err := gee.Get("broken")
+	if !errors.Is(err, ErrLoaderFailed) || errors.Unwrap(err).Error() != "connection refused" {
+		t.Fatalf("expect ErrLoaderFailed wrapping the getter error, got %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := gee.Get("unknown"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expect ErrNotFound, got %v", err)
+		}
+	}
+	if loads["unknown"] != 1 || gee.Stats().NegativeHits != 2 {
+		t.Fatalf("not found key should be cached, loads=%d", loads["unknown"])
+	}
+	//写入之后不再返回 ErrNotFound
+	gee.Set("unknown", []byte("1"))
+	if view, err := gee.Get("unknown"); err != nil || view.String() != "1" {
+		t.Fatalf("Set should clear the negative cache, got %v", err)
+	}
+
+	//错误通过 HTTP 状态码在节点之间传递
+	srv := httptest.NewServer(NewHTTPPool("self", WithCache(c)))
+	defer srv.Close()
+	getter := &httpGetter{baseURL: srv.URL + defaultBasePath}
+	for key, target := range map[string]error{
+		"missing": ErrNotFound,
+		"broken":  ErrLoaderFailed,
+	} {
+		err := getter.Get(&pb.Request{Group: "errors", Key: key}, &pb.Response{})
+		if !errors.Is(err, target) {
+			t.Fatalf("%s: expect %v, got %v", key, target, err)
+		}
+	}
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	err = (&httpGetter{baseURL: closed.URL + defaultBasePath}).Get(&pb.Request{Group: "errors", Key: "Tom"}, &pb.Response{})
+	if !errors.Is(err, ErrPeerUnavailable) || HTTPStatus(err) != http.StatusBadGateway {
+		t.Fatalf("expect ErrPeerUnavailable, got %v", err)
+	}
+}
+
+func TestGRPCPool(t *testing.T) {
+	c := New()
+	c.NewGroup("grpc", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist: %w", key, ErrNotFound)
+		}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	server := NewGRPCPool(lis.Addr().String(), WithGRPCCache(c))
+	go server.Serve(lis)
+
+	//客户端节点只知道远程节点，所有 key 都属于它
+	client := NewGRPCPool("127.0.0.1:1")
+	if err := client.SetPeers(lis.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	peer, ok := client.PickPeer("Tom")
+	if !ok {
+		t.Fatalf("Tom should belong to the remote peer")
+	}
+
+	res := &pb.Response{}
+	if err := peer.Get(&pb.Request{Group: "grpc", Key: "Tom"}, res); err != nil || string(res.Value) != "630" {
+		t.Fatalf("failed to get Tom over grpc: %s %v", res.Value, err)
+	}
+	err = peer.Get(&pb.Request{Group: "grpc", Key: "unknown"}, &pb.Response{})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+
+	batch := &pb.BatchResponse{}
+	err = peer.(BatchPeerGetter).GetMany(context.Background(), &pb.BatchRequest{Group: "grpc", Keys: []string{"Tom", "unknown"}}, batch)
+	if values, errs := c.GetGroup("grpc").fromBatchResponse(batch); err != nil || values["Tom"].String() != "630" || !errors.Is(errs["unknown"], ErrNotFound) {
+		t.Fatalf("GetMany over grpc failed: %v %v %v", values, errs, err)
+	}
+
+	if err := peer.Set(&pb.SetRequest{Group: "grpc", Key: "Sam", Value: []byte("600")}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := peer.Get(&pb.Request{Group: "grpc", Key: "Sam"}, res); err != nil || string(res.Value) != "600" {
+		t.Fatalf("Set over grpc failed: %s %v", res.Value, err)
+	}
+	if err := peer.Remove(&pb.Request{Group: "grpc", Key: "Sam"}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.GetGroup("grpc").mainCache.get("Sam"); ok {
+		t.Fatalf("Remove over grpc failed")
+	}
+
+	//大值分成多个消息发送，不受 gRPC 默认 4MB 消息大小的限制
+	big := bytes.Repeat([]byte("x"), 5<<20)
+	c.NewGroup("grpc-big", 0, GetterFunc(func(key string) ([]byte, error) {
+		return big, nil
+	}))
+	if err := peer.Get(&pb.Request{Group: "grpc-big", Key: "big"}, res); err != nil || !bytes.Equal(res.Value, big) {
+		t.Fatalf("failed to get a 5MB value over grpc: %d bytes, %v", len(res.Value), err)
+	}
+	limited := NewGRPCPool("127.0.0.1:1", WithGRPCMaxResponseSize(1<<20))
+	limited.SetPeers(lis.Addr().String())
+	defer limited.Close()
+	peer, _ = limited.PickPeer("big")
+	if err := peer.Get(&pb.Request{Group: "grpc-big", Key: "big"}, &pb.Response{}); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expect ErrValueTooLarge, got %v", err)
+	}
+
+	//连接不上的节点返回 ErrPeerUnavailable
+	dead := NewGRPCPool("127.0.0.1:1")
+	dead.SetPeers("127.0.0.1:2")
+	defer dead.Close()
+	peer, _ = dead.PickPeer("Tom")
+	if err := peer.Get(&pb.Request{Group: "grpc", Key: "Tom"}, res); !errors.Is(err, ErrPeerUnavailable) {
+		t.Fatalf("expect ErrPeerUnavailable, got %v", err)
+	}
+}
+
+func TestHTTPPoolMembership(t *testing.T) {
+	pool := NewHTTPPool("http://node1")
+	pool.Set("http://node1", "http://node2", "http://node3")
+	srv := httptest.NewServer(pool)
+	defer srv.Close()
+
+	owners := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		owners[key] = pool.peers.Get(key)
+	}
+
+	res, err := http.Post(srv.URL+defaultAdminPath+"peers?peer=http://node4&peer=http://node5", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var peers []string
+	json.NewDecoder(res.Body).Decode(&peers)
+	res.Body.Close()
+	if len(peers) != 5 {
+		t.Fatalf("expect 5 peers, got %v", peers)
+	}
+	//加入新节点之后，key 要么不动，要么移动到新节点
+	for key, owner := range owners {
+		now := pool.peers.Get(key)
+		if now != owner && now != "http://node4" && now != "http://node5" {
+			t.Fatalf("key %s moved from %s to %s", key, owner, now)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL+defaultAdminPath+"peers?peer=http://node4&peer=http://node5", nil)
+	if res, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	for key, owner := range owners {
+		if now := pool.peers.Get(key); now != owner {
+			t.Fatalf("key %s should go back to %s, got %s", key, owner, now)
+		}
+	}
+	if len(pool.Peers()) != 3 || len(pool.AllPeers()) != 2 {
+		t.Fatalf("unexpected peers %v", pool.Peers())
+	}
+}
+
+// 由测试控制节点列表的注册中心
+type fakeRegistry struct {
+	registered string
+	updates    chan []string
+}
+
+func (r *fakeRegistry) Register(ctx context.Context, addr string) error {
+	r.registered = addr
+	return nil
+}
+
+func (r *fakeRegistry) Watch(ctx context.Context) (<-chan []string, error) {
+	return r.updates, nil
+}
+
+func TestHTTPPoolDiscover(t *testing.T) {
+	pool := NewHTTPPool("http://node1")
+	r := &fakeRegistry{updates: make(chan []string)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := pool.Discover(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	if r.registered != "http://node1" {
+		t.Fatalf("expect to register http://node1, got %q", r.registered)
+	}
+
+	waitPeers := func(want ...string) {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			if reflect.DeepEqual(pool.Peers(), want) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expect peers %v, got %v", want, pool.Peers())
+	}
+	r.updates <- []string{"http://node1", "http://node2", "http://node3"}
+	waitPeers("http://node1", "http://node2", "http://node3")
+	//node3 心跳超时，node4 加入
+	r.updates <- []string{"http://node1", "http://node2", "http://node4"}
+	waitPeers("http://node1", "http://node2", "http://node4")
+	close(r.updates)
+}
+
+func TestGRPCPoolDiscover(t *testing.T) {
+	pool := NewGRPCPool("127.0.0.1:1")
+	defer pool.Close()
+	r := &fakeRegistry{updates: make(chan []string)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := pool.Discover(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	if r.registered != "127.0.0.1:1" {
+		t.Fatalf("expect to register 127.0.0.1:1, got %q", r.registered)
+	}
+
+	getter := func(addr string) *grpcGetter {
+		pool.mutex.Lock()
+		defer pool.mutex.Unlock()
+		return pool.grpcGetters[addr]
+	}
+	waitPeers := func(want ...string) {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			if len(pool.AllPeers()) == len(want) && getter(want[0]) != nil && getter(want[len(want)-1]) != nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expect peers %v, got %d peers", want, len(pool.AllPeers()))
+	}
+	r.updates <- []string{"127.0.0.1:1", "127.0.0.1:2", "127.0.0.1:3"}
+	waitPeers("127.0.0.1:2", "127.0.0.1:3")
+	kept := getter("127.0.0.1:2")
+	//127.0.0.1:3 心跳超时，127.0.0.1:4 加入，127.0.0.1:2 继续使用原来的连接
+	r.updates <- []string{"127.0.0.1:1", "127.0.0.1:2", "127.0.0.1:4"}
+	waitPeers("127.0.0.1:2", "127.0.0.1:4")
+	if getter("127.0.0.1:2") != kept || getter("127.0.0.1:3") != nil {
+		t.Fatalf("only changed peers should be redialed")
+	}
+	close(r.updates)
+}
+
+func TestHTTPPoolBreaker(t *testing.T) {
+	c := New()
+	c.NewGroup("breaker", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte("db-" + key), nil
+		}))
+	alive := httptest.NewServer(NewHTTPPool("alive", WithCache(c)))
+	defer alive.Close()
+	//down 为 1 时模拟节点故障，包括健康检查
+	var down int32 = 1
+	flakyPool := NewHTTPPool("flaky", WithCache(c))
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		flakyPool.ServeHTTP(w, r)
+	}))
+	defer flaky.Close()
+
+	res, err := http.Get(alive.URL + defaultBasePath + healthPath)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("health check should succeed, got %v %v", res, err)
+	}
+	res.Body.Close()
+
+	pool := NewHTTPPool("http://self", WithCache(c), WithCircuitBreaker(2, 50*time.Millisecond))
+	pool.Set(flaky.URL, alive.URL)
+	//找一个顺时针依次属于 flaky、alive 的 key
+	var key string
+	for i := 0; key == ""; i++ {
+		var nodes []string
+		pool.peers.Walk(strconv.Itoa(i), func(node string) bool {
+			nodes = append(nodes, node)
+			return true
+		})
+		if nodes[0] == flaky.URL {
+			key = strconv.Itoa(i)
+		}
+	}
+
+	get := func() (PeerGetter, string) {
+		t.Helper()
+		peer, ok := pool.PickPeer(key)
+		if !ok {
+			t.Fatalf("key %s should belong to a peer", key)
+		}
+		out := &pb.Response{}
+		if err := peer.(ContextPeerGetter).GetContext(context.Background(), &pb.Request{Group: "breaker", Key: key}, out); err != nil {
+			t.Fatal(err)
+		}
+		return peer, string(out.Value)
+	}
+	//所属节点故障时由顺时针的下一个节点返回
+	for i := 0; i < 2; i++ {
+		if peer, v := get(); v != "db-"+key {
+			t.Fatalf("expect db-%s, got %s", key, v)
+		} else if _, ok := peer.(*retryGetter); !ok {
+			t.Fatalf("expect retry on next peer before circuit opens, got %T", peer)
+		}
+	}
+	//连续失败之后熔断，直接选择下一个节点
+	if peer, _ := get(); peer != pool.httpGetters[alive.URL] {
+		t.Fatalf("circuit should be open for %s", flaky.URL)
+	}
+
+	//节点恢复之后，健康检查成功，重新成为 key 的所属节点
+	atomic.StoreInt32(&down, 0)
+	for i := 0; ; i++ {
+		peer, _ := get()
+		if r, ok := peer.(*retryGetter); ok && r.getters[0] == pool.httpGetters[flaky.URL] {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("circuit should be closed after the peer recovers")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 把远程节点负责的 key（以 remote 开头）交给 peer
+type batchPicker struct {
+	peer PeerGetter
+}
+
+func (p *batchPicker) PickPeer(key string) (PeerGetter, bool) {
+	if strings.HasPrefix(key, "remote") {
+		return p.peer, true
+	}
+	return nil, false
+}
+
+func TestGetMany(t *testing.T) {
+	var batches [][]string
+	loader := BatchGetterFunc(func(ctx context.Context, keys []string) (map[string][]byte, error) {
+		batches = append(batches, keys)
+		values := make(map[string][]byte)
+		for _, key := range keys {
+			if v, ok := db[strings.TrimPrefix(key, "remote")]; ok {
+				values[key] = []byte(v)
+			}
+		}
+		return values, nil
+	})
+	gee := New().NewGroup("batch", 2<<10, loader)
+	//远程节点使用自己的 Cache，不会和本地的同名 Group 冲突
+	remote := New()
+	remote.NewGroup("batch", 2<<10, loader)
+
+	var posts int32
+	server := NewHTTPPool("server", WithCache(remote))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			atomic.AddInt32(&posts, 1)
+		}
+		server.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	gee.RegisterPeers(&batchPicker{peer: &httpGetter{baseURL: srv.URL + defaultBasePath}})
+
+	keys := []string{"Tom", "Jack", "unknown", "Tom", "remoteSam", "remoteTom", "remoteunknown"}
+	values, err := gee.GetMany(context.Background(), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"Tom": "630", "Jack": "589", "remoteSam": "567", "remoteTom": "630"}
+	if len(values) != len(want) {
+		t.Fatalf("expect %d values, got %v", len(want), values)
+	}
+	for k, v := range want {
+		if values[k].String() != v {
+			t.Fatalf("expect %s=%s, got %s", k, v, values[k].String())
+		}
+	}
+	//远程节点只收到一次请求，两边的 Getter 各被调用一次
+	if posts != 1 {
+		t.Fatalf("expect 1 batch request to the peer, got %d", posts)
+	}
+	if len(batches) != 2 {
+		t.Fatalf("expect 2 batch loads, got %v", batches)
+	}
+
+	//本地的 key 已经缓存，不再回源
+	if _, err := gee.GetMany(context.Background(), []string{"Tom", "Jack"}); err != nil || len(batches) != 2 {
+		t.Fatalf("cached keys should not be loaded again, got %v %v", batches, err)
+	}
+	if _, err := gee.GetMany(context.Background(), []string{""}); !errors.Is(err, ErrEmptyKey) {
+		t.Fatalf("expect ErrEmptyKey, got %v", err)
+	}
+}
+
+// 合并后的批量回源不继承发起调用者的取消，等待同一批 key 的其他调用者仍然能得到结果
+func TestGetManyDetached(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	gee := New().NewGroup("batch-detached", 2<<10, BatchGetterFunc(
+		func(loadCtx context.Context, keys []string) (map[string][]byte, error) {
+			cancel()
+			if err := loadCtx.Err(); err != nil {
+				return nil, err
+			}
+			values := make(map[string][]byte)
+			for _, key := range keys {
+				values[key] = []byte(db[key])
+			}
+			return values, nil
+		}))
+	if _, err := gee.GetMany(ctx, []string{"Tom", "Jack"}); err != nil {
+		t.Fatalf("shared load should not see the caller's cancel, got %v", err)
+	}
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "630" {
+		t.Fatalf("loaded keys should be cached, got %v %v", v, err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	c := New()
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist: %w", key, ErrNotFound)
+	})
+	//每条记录 2 字节，最多容纳 3 条
+	gee := c.NewGroup("snapshot", 6, getter, WithHotCache(0))
+	gee.populateCache("a", ByteView{b: []byte("1")}, time.Time{})
+	gee.populateCache("b", ByteView{b: []byte("2")}, time.Now().Add(time.Hour))
+	gee.populateCache("c", ByteView{b: []byte("3")}, time.Time{})
+	//访问 a 之后，b 成为最先被淘汰的记录
+	gee.Get("a")
+
+	var buf bytes.Buffer
+	if err := gee.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	restored := c.NewGroup("snapshot-restored", 6, getter, WithHotCache(0))
+	if err := restored.Restore(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]string{"a": "1", "b": "2", "c": "3"} {
+		if view, err := restored.Get(k); err != nil || view.String() != v {
+			t.Fatalf("expect %s=%s after restore, got %s %v", k, v, view.String(), err)
+		}
+	}
+	if view, _ := restored.mainCache.get("b"); view.Expire().IsZero() {
+		t.Fatalf("expire time should be restored")
+	}
+
+	//快照中的顺序是 b、c、a，重新恢复之后写入新记录淘汰 b
+	restored = c.ReplaceGroup("snapshot-restored", 6, getter, WithHotCache(0))
+	restored.Restore(bytes.NewReader(data))
+	restored.populateCache("d", ByteView{b: []byte("4")}, time.Time{})
+	if _, ok := restored.mainCache.get("b"); ok {
+		t.Fatalf("b should be evicted first, LRU order is not preserved")
+	}
+	for _, k := range []string{"a", "c", "d"} {
+		if _, ok := restored.mainCache.get(k); !ok {
+			t.Fatalf("%s should survive the eviction", k)
+		}
+	}
+
+	//损坏的快照不会写入任何记录
+	for _, bad := range [][]byte{
+		data[:len(data)-1],
+		append([]byte("NOTCACHE"), data[8:]...),
+		append(append([]byte{}, data[:len(data)-5]...), data[len(data)-5]^0xff, 0, 0, 0, 0),
+	} {
+		empty := c.ReplaceGroup("snapshot-bad", 6, getter)
+		if err := empty.Restore(bytes.NewReader(bad)); !errors.Is(err, ErrBadSnapshot) {
+			t.Fatalf("expect ErrBadSnapshot, got %v", err)
+		}
+		if empty.CacheStats(MainCache).Items != 0 {
+			t.Fatalf("bad snapshot should not populate the cache")
+		}
+	}
+}
+
+func TestDiskTier(t *testing.T) {
+	c := New()
+	store, err := disk.Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	loads := 0
+	//每条记录 2 字节，内存中最多容纳 3 条
+	gee := c.NewGroup("disk", 6, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte(strings.ToUpper(key)), nil
+		}), WithHotCache(0), WithDiskTier(store))
+
+	for _, k := range []string{"a", "b", "c", "d"} {
+		gee.Get(k)
+	}
+	//a 被淘汰到磁盘上
+	if _, ok := gee.mainCache.get("a"); ok || store.Len() != 1 {
+		t.Fatalf("a should be spilled to disk, disk has %d records", store.Len())
+	}
+	if v, err := gee.Get("a"); err != nil || v.String() != "A" || loads != 4 {
+		t.Fatalf("a should be served from disk, got %s %v, %d loads", v.String(), err, loads)
+	}
+	if gee.Stats().DiskHits != 1 {
+		t.Fatalf("expect 1 disk hit, got %d", gee.Stats().DiskHits)
+	}
+	//a 移回内存，b 被淘汰到磁盘上
+	if _, _, ok, _ := store.Get("a"); ok {
+		t.Fatalf("a should be moved back to memory")
+	}
+	if _, _, ok, _ := store.Get("b"); !ok {
+		t.Fatalf("b should be spilled to disk")
+	}
+
+	gee.Remove("b")
+	if _, _, ok, _ := store.Get("b"); ok {
+		t.Fatalf("Remove should delete the disk copy")
+	}
+
+	//压缩过的值写入磁盘之后，关闭压缩的 Group 也能读出来
+	codec, _ := CompressorByName("flate")
+	long := func(key string) ([]byte, error) {
+		return []byte(strings.Repeat(key, 100)), nil
+	}
+	compressed := c.NewGroup("disk-compressed", 60, GetterFunc(long), WithHotCache(0), WithDiskTier(store), WithCompression(codec))
+	for _, k := range []string{"x", "y", "z", "w"} {
+		compressed.Get(k)
+	}
+	if _, _, ok, _ := store.Get("x"); !ok {
+		t.Fatalf("x should be spilled to disk")
+	}
+	plain := c.NewGroup("disk-plain", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}), WithDiskTier(store))
+	if v, err := plain.Get("x"); err != nil || v.String() != strings.Repeat("x", 100) {
+		t.Fatalf("compressed disk record should be readable without compression, got %q %v", v.String(), err)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	c := New()
+	big := strings.Repeat("geecache", 1000)
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		if key == "big" {
+			return []byte(big), nil
+		}
+		return []byte(key), nil
+	})
+	flate, _ := CompressorByName("flate")
+	gee := c.NewGroup("compress", 1<<20, getter, WithCompression(flate), WithHotCache(0))
+	for i := 0; i < 2; i++ {
+		if v, err := gee.Get("big"); err != nil || v.String() != big {
+			t.Fatalf("failed to get big value: %v", err)
+		}
+	}
+	if bytes := gee.CacheStats(MainCache).Bytes; bytes > int64(len(big))/4 {
+		t.Fatalf("value should be stored compressed, mainCache uses %d bytes", bytes)
+	}
+
+	c.NewGroup("plain", 1<<20, getter, WithHotCache(0))
+	limited := c.NewGroup("compress-limited", 1<<20, getter, WithMaxValueSize(100))
+
+	//压缩过的值原样发送，由请求方解压；大值不编码成 pb.Response
+	for _, threshold := range []int{defaultStreamThreshold, 16} {
+		srv := httptest.NewServer(NewHTTPPool("self", WithCache(c), WithStreamThreshold(threshold)))
+		getter := &httpGetter{baseURL: srv.URL + defaultBasePath}
+		res := &pb.Response{}
+		if err := getter.Get(&pb.Request{Group: "compress", Key: "big"}, res); err != nil {
+			t.Fatal(err)
+		}
+		if res.GetEncoding() != "flate" || len(res.GetValue()) >= len(big) {
+			t.Fatalf("value should be compressed on the wire, got %q with %d bytes", res.GetEncoding(), len(res.GetValue()))
+		}
+		if v, err := gee.getFromPeer(context.Background(), getter, "big"); err != nil || v.String() != big {
+			t.Fatalf("failed to decode value from peer: %v", err)
+		}
+
+		//超过上限的值
+		if _, err := limited.getFromPeer(context.Background(), getter, "big"); !errors.Is(err, ErrValueTooLarge) {
+			t.Fatalf("decompressed value over the limit should fail, got %v", err)
+		}
+		small := &httpGetter{baseURL: getter.baseURL, maxBytes: 100}
+		err := small.Get(&pb.Request{Group: "plain", Key: "big"}, &pb.Response{})
+		if !errors.Is(err, ErrValueTooLarge) {
+			t.Fatalf("response over the limit should fail, got %v", err)
+		}
+		srv.Close()
+	}
+
+	if err := limited.Set("key", make([]byte, 101)); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expect ErrValueTooLarge, got %v", err)
+	}
+
+	//快照中保存解压后的值
+	var buf bytes.Buffer
+	if err := gee.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored := c.NewGroup("compress-restored", 1<<20, getter, WithHotCache(0))
+	if err := restored.Restore(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := restored.mainCache.get("big"); !ok || v.String() != big {
+		t.Fatalf("snapshot should contain the decompressed value")
+	}
+}
+
+func TestTypedGroup(t *testing.T) {
+	c := New()
+	type user struct {
+		Name string
+		Age  int
+	}
+	var loads int32
+	getUser := func(ctx context.Context, key string) (user, error) {
+		atomic.AddInt32(&loads, 1)
+		if key == "unknown" {
+			return user{}, ErrNotFound
+		}
+		return user{Name: key, Age: len(key)}, nil
+	}
+	for name, codec := range map[string]Codec[user]{
+		"typed-json": JSONCodec[user]{},
+		"typed-gob":  GobCodec[user]{},
+	} {
+		atomic.StoreInt32(&loads, 0)
+		users := NewTypedGroupIn(c, name, 2<<10, codec, getUser)
+		for i := 0; i < 2; i++ {
+			u, err := users.Get(context.Background(), "Tom")
+			if err != nil || u != (user{Name: "Tom", Age: 3}) {
+				t.Fatalf("%s: failed to get Tom, got %+v, %v", name, u, err)
+			}
+		}
+		if n := atomic.LoadInt32(&loads); n != 1 {
+			t.Fatalf("%s: Tom should be loaded once, loaded %d times", name, n)
+		}
+		if _, err := users.Get(context.Background(), "unknown"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("%s: expect ErrNotFound, got %v", name, err)
+		}
+
+		if err := users.Set("Jack", user{Name: "Jack", Age: 30}); err != nil {
+			t.Fatal(err)
+		}
+		values, err := users.GetMany(context.Background(), []string{"Tom", "Jack", "unknown"})
+		if err != nil || len(values) != 2 || values["Jack"].Age != 30 {
+			t.Fatalf("%s: GetMany returned %+v, %v", name, values, err)
+		}
+
+		//底层的值不是合法的编码时返回解码错误
+		users.Group().Set("bad", []byte{0xff})
+		if _, err := users.Get(context.Background(), "bad"); err == nil {
+			t.Fatalf("%s: decoding a corrupt value should fail", name)
+		}
+	}
+
+	responses := NewTypedGroupIn[*pb.Response](c, "typed-proto", 2<<10, ProtoCodec[*pb.Response]{},
+		func(ctx context.Context, key string) (*pb.Response, error) {
+			return &pb.Response{Value: []byte(key), Expire: 42}, nil
+		})
+	for i := 0; i < 2; i++ {
+		res, err := responses.Get(context.Background(), "Tom")
+		if err != nil || string(res.GetValue()) != "Tom" || res.GetExpire() != 42 {
+			t.Fatalf("failed to get proto message, got %v, %v", res, err)
+		}
+	}
+}
+
+func TestGroupLifecycle(t *testing.T) {
+	c := New()
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte("v"), nil
+	})
+	gee := c.NewGroup("lifecycle", 1<<10, getter, WithHotCache(0))
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("NewGroup with a duplicate name should panic")
+			}
+		}()
+		c.NewGroup("lifecycle", 1<<10, getter)
+	}()
+	if c.GetGroup("lifecycle") != gee {
+		t.Fatalf("duplicate NewGroup should not replace the existing group")
+	}
+	names := c.ListGroups()
+	if !sort.StringsAreSorted(names) || sort.SearchStrings(names, "lifecycle") == len(names) {
+		t.Fatalf("ListGroups should return sorted names including lifecycle, got %v", names)
+	}
+
+	//每条记录 3 个字节，缩小之后只保留最近访问的 3 条
+	for i := 0; i < 10; i++ {
+		gee.Get("k" + strconv.Itoa(i))
+	}
+	if err := gee.Resize(9); err != nil {
+		t.Fatal(err)
+	}
+	if gee.CacheBytes() != 9 {
+		t.Fatalf("expect 9 bytes after resize, got %d", gee.CacheBytes())
+	}
+	if st := gee.CacheStats(MainCache); st.Items != 3 || st.Bytes != 9 {
+		t.Fatalf("unexpected stats after resize %+v", st)
+	}
+	for i := 0; i < 10; i++ {
+		if _, ok := gee.mainCache.get("k" + strconv.Itoa(i)); ok != (i >= 7) {
+			t.Fatalf("k%d: expect cached=%v after resize", i, i >= 7)
+		}
+	}
+
+	replaced := c.ReplaceGroup("lifecycle", 1<<10, getter)
+	if c.GetGroup("lifecycle") != replaced {
+		t.Fatalf("ReplaceGroup should register the new group")
+	}
+	if _, err := gee.Get("k9"); !errors.Is(err, ErrGroupClosed) {
+		t.Fatalf("replaced group should be closed, got %v", err)
+	}
+	if st := gee.CacheStats(MainCache); st.Items != 0 {
+		t.Fatalf("closed group should release its cache, got %+v", st)
+	}
+	if !c.DestroyGroup("lifecycle") || c.DestroyGroup("lifecycle") || c.GetGroup("lifecycle") != nil {
+		t.Fatalf("DestroyGroup should remove the group exactly once")
+	}
+	if err := replaced.Set("k", []byte("v")); !errors.Is(err, ErrGroupClosed) {
+		t.Fatalf("expect ErrGroupClosed, got %v", err)
+	}
+
+	//管理接口
+	c.NewGroup("lifecycle-admin", 1<<10, getter)
+	srv := httptest.NewServer(NewHTTPPool("self", WithCache(c)))
+	defer srv.Close()
+	do := func(method, query string) (int, []groupInfo) {
+		req, _ := http.NewRequest(method, srv.URL+defaultAdminPath+"groups"+query, nil)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var list []groupInfo
+		json.NewDecoder(res.Body).Decode(&list)
+		return res.StatusCode, list
+	}
+	find := func(list []groupInfo) *groupInfo {
+		for i := range list {
+			if list[i].Name == "lifecycle-admin" {
+				return &list[i]
+			}
+		}
+		return nil
+	}
+	if code, list := do(http.MethodGet, ""); code != http.StatusOK || find(list) == nil || find(list).CacheBytes != 1<<10 {
+		t.Fatalf("lifecycle-admin should be listed, got %d %+v", code, list)
+	}
+	if code, list := do(http.MethodPut, "?group=lifecycle-admin&bytes=100"); code != http.StatusOK || find(list).CacheBytes != 100 {
+		t.Fatalf("failed to resize group, got %d %+v", code, list)
+	}
+	if code, _ := do(http.MethodPut, "?group=lifecycle-admin&bytes=-1"); code != http.StatusBadRequest {
+		t.Fatalf("expect 400 for bad size, got %d", code)
+	}
+	if code, list := do(http.MethodDelete, "?group=lifecycle-admin"); code != http.StatusOK || find(list) != nil {
+		t.Fatalf("failed to destroy group, got %d %+v", code, list)
+	}
+	if code, _ := do(http.MethodDelete, "?group=lifecycle-admin"); code != http.StatusNotFound {
+		t.Fatalf("expect 404 for unknown group, got %d", code)
+	}
+}
+
+func TestCache(t *testing.T) {
+	//同一个进程中的两个节点，各自使用自己的 Cache
+	var loads [2]int32
+	var pools [2]*HTTPPool
+	var caches [2]*Cache
+	var urls []string
+	for i := range pools {
+		i := i
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pools[i].ServeHTTP(w, r)
+		}))
+		defer srv.Close()
+		urls = append(urls, srv.URL)
+		caches[i] = New()
+		caches[i].NewGroup("scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+			atomic.AddInt32(&loads[i], 1)
+			return []byte(key), nil
+		}))
+	}
+	for i := range pools {
+		pools[i] = NewHTTPPool(urls[i], WithCache(caches[i]))
+		pools[i].Set(urls...)
+		caches[i].RegisterPeers(pools[i])
+	}
+	if GetGroup("scores") == caches[0].GetGroup("scores") {
+		t.Fatalf("groups in a Cache should not be visible through the default Cache")
+	}
+
+	//每个 key 只由所属的节点回源，无论从哪个节点访问
+	for i := 0; i < 20; i++ {
+		key := strconv.Itoa(i)
+		for _, c := range caches {
+			if v, err := c.GetGroup("scores").Get(key); err != nil || v.String() != key {
+				t.Fatalf("failed to get %s: %v", key, err)
+			}
+		}
+	}
+	if loads[0]+loads[1] != 20 || loads[0] == 0 || loads[1] == 0 {
+		t.Fatalf("each key should be loaded once by its owner, got %v", loads)
+	}
+
+	//RegisterPeers 之后创建的 Group 也使用节点池
+	if g := caches[0].NewGroup("later", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})); g.getPeers() != pools[0] {
+		t.Fatalf("group created after RegisterPeers should use the pool")
+	}
+	if names := caches[1].ListGroups(); !reflect.DeepEqual(names, []string{"scores"}) {
+		t.Fatalf("caches should not share groups, got %v", names)
+	}
+}
+
+// Group 正在回源时注册节点池，go test -race 下不应该报告数据竞争
+func TestRegisterPeersWhileServing(t *testing.T) {
+	c := New()
+	gee := c.NewGroup("serving", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithHotCache(0))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			gee.Get(strconv.Itoa(i))
+		}
+	}()
+	peer := &fakePeer{sets: map[string][]byte{"remote": []byte("v")}}
+	c.RegisterPeers(peer)
+	<-done
+	if v, err := gee.Get("remote"); err != nil || v.String() != "v" {
+		t.Fatalf("group should use the pool registered while serving, got %v %v", v, err)
+	}
+}
+
+func TestReplicas(t *testing.T) {
+	//三个节点，每个 key 保存在两个节点上
+	var loads int32
+	var pools [3]*HTTPPool
+	var caches [3]*Cache
+	var servers [3]*httptest.Server
+	var urls []string
+	for i := range pools {
+		i := i
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pools[i].ServeHTTP(w, r)
+		}))
+		defer servers[i].Close()
+		urls = append(urls, servers[i].URL)
+		caches[i] = New()
+		caches[i].NewGroup("scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+			atomic.AddInt32(&loads, 1)
+			return []byte(key), nil
+		}), WithReplicas(2),
+			//hotCache 随机抽样，抽中时第二次 Get 不会访问任何节点
+			WithHotCache(0))
+	}
+	for i := range pools {
+		pools[i] = NewHTTPPool(urls[i], WithCache(caches[i]))
+		pools[i].Set(urls...)
+		caches[i].RegisterPeers(pools[i])
+	}
+	owner := func(peer PeerGetter) int {
+		for i, u := range urls {
+			if peer.(*httpGetter).baseURL == u+defaultBasePath {
+				return i
+			}
+		}
+		t.Fatalf("unknown peer %v", peer)
+		return -1
+	}
+
+	//找不属于第三个节点的 key，从第三个节点访问
+	remoteKey := func(from int) (key string, primary, replica int) {
+		for i := from; ; i++ {
+			key = strconv.Itoa(i)
+			if owners := pools[2].PickReplicas(key, 2); owners[0] != nil && owners[1] != nil {
+				return key, owner(owners[0]), owner(owners[1])
+			}
+		}
+	}
+	reader := caches[2].GetGroup("scores")
+	cached := func(node int, key string) bool {
+		_, ok := caches[node].GetGroup("scores").mainCache.get(key)
+		return ok
+	}
+
+	//Set 和 Remove 作用于所有副本
+	key, primary, replica := remoteKey(1000)
+	if err := reader.Set(key, []byte("v")); err != nil {
+		t.Fatalf("failed to set %s: %v", key, err)
+	}
+	if !cached(primary, key) || !cached(replica, key) || cached(2, key) {
+		t.Fatalf("Set should write %s to both owners only", key)
+	}
+	if err := reader.Remove(key); err != nil {
+		t.Fatalf("failed to remove %s: %v", key, err)
+	}
+	if cached(primary, key) || cached(replica, key) {
+		t.Fatalf("Remove should delete %s from both owners", key)
+	}
+
+	key, primary, replica = remoteKey(0)
+	if v, err := reader.Get(key); err != nil || v.String() != key {
+		t.Fatalf("failed to get %s: %v", key, err)
+	}
+	//主节点回源之后异步写入副本
+	deadline := time.Now().Add(5 * time.Second)
+	for !cached(replica, key) {
+		if time.Now().After(deadline) {
+			t.Fatalf("value was not replicated to %s", urls[replica])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if caches[primary].GetGroup("scores").Stats().LocalLoads != 1 {
+		t.Fatalf("primary should load the key")
+	}
+
+	//主节点下线之后从副本读取，不需要再回源
+	servers[primary].Close()
+	if v, err := reader.Get(key); err != nil || v.String() != key {
+		t.Fatalf("failed to get %s after primary failed: %v", key, err)
+	}
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Fatalf("replica hit should not load again, got %d loads", n)
+	}
+	if s := reader.Stats(); s.ReplicaLoads != 1 || s.PeerErrors != 1 {
+		t.Fatalf("expected one replica load after one peer error, got %+v", s)
+	}
+}
+
+// 生成测试用的 CA 和由它签发的节点证书，节点证书同时用于服务端和客户端
+func newTestPKI(t *testing.T) (*x509.CertPool, tls.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "geecache test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(crand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "geecache node"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(crand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	return roots, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestHTTPPoolMutualTLS(t *testing.T) {
+	roots, cert := newTestPKI(t)
+	_, untrusted := newTestPKI(t)
+	c := New()
+	c.NewGroup("auth", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	server := NewHTTPPool("server", WithCache(c), WithMutualTLS(cert, roots))
+	srv := httptest.NewUnstartedServer(server)
+	srv.TLS = server.TLSConfig()
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := NewHTTPPool("client", WithMutualTLS(cert, roots))
+	client.Set(srv.URL)
+	peer, ok := client.PickPeer("Tom")
+	if !ok {
+		t.Fatalf("expect the server to be picked")
+	}
+	res := &pb.Response{}
+	if err := peer.Get(&pb.Request{Group: "auth", Key: "Tom"}, res); err != nil || string(res.GetValue()) != "Tom" {
+		t.Fatalf("failed to get over mTLS: %v", err)
+	}
+
+	//服务端不信任请求方的证书，握手失败
+	stranger := NewHTTPPool("client", WithMutualTLS(untrusted, roots))
+	stranger.Set(srv.URL)
+	peer, _ = stranger.PickPeer("Tom")
+	if err := peer.Get(&pb.Request{Group: "auth", Key: "Tom"}, &pb.Response{}); !errors.Is(err, ErrPeerUnavailable) {
+		t.Fatalf("untrusted certificate should be rejected, got %v", err)
+	}
+
+	//服务没有使用 TLSConfig 启动时，拒绝没有证书的请求，健康检查除外
+	plain := httptest.NewServer(server)
+	defer plain.Close()
+	for path, want := range map[string]int{
+		defaultBasePath + "auth/Tom": http.StatusForbidden,
+		defaultAdminPath + "groups":  http.StatusForbidden,
+		defaultBasePath + healthPath: http.StatusOK,
+	} {
+		res, err := http.Get(plain.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Fatalf("%s: expect %d, got %d", path, want, res.StatusCode)
+		}
+	}
+}
+
+func TestGRPCPoolMutualTLS(t *testing.T) {
+	roots, cert := newTestPKI(t)
+	_, untrusted := newTestPKI(t)
+	c := New()
+	c.NewGroup("auth", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	server := NewGRPCPool(lis.Addr().String(), WithGRPCCache(c), WithGRPCMutualTLS(cert, roots))
+	go server.Serve(lis)
+
+	client := NewGRPCPool("127.0.0.1:1", WithGRPCMutualTLS(cert, roots))
+	client.SetPeers(lis.Addr().String())
+	defer client.Close()
+	peer, _ := client.PickPeer("Tom")
+	res := &pb.Response{}
+	if err := peer.Get(&pb.Request{Group: "auth", Key: "Tom"}, res); err != nil || string(res.GetValue()) != "Tom" {
+		t.Fatalf("failed to get over grpc mTLS: %v", err)
+	}
+
+	//证书不可信或者没有使用 TLS 的请求方都连接不上
+	for name, opts := range map[string][]GRPCPoolOption{
+		"untrusted": {WithGRPCMutualTLS(untrusted, roots)},
+		"plain":     nil,
+	} {
+		stranger := NewGRPCPool("127.0.0.1:1", append(opts, WithGRPCTimeout(time.Second))...)
+		stranger.SetPeers(lis.Addr().String())
+		peer, _ := stranger.PickPeer("Tom")
+		if err := peer.Get(&pb.Request{Group: "auth", Key: "Tom"}, &pb.Response{}); !errors.Is(err, ErrPeerUnavailable) {
+			t.Fatalf("%s: expect ErrPeerUnavailable, got %v", name, err)
+		}
+		stranger.Close()
+	}
+}
+
+func TestHTTPPoolSharedSecret(t *testing.T) {
+	secret := []byte("secret")
+	c := New()
+	c.NewGroup("auth", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	srv := httptest.NewServer(NewHTTPPool("server", WithCache(c), WithSharedSecret(secret)))
+	defer srv.Close()
+
+	//GET、PUT、POST 都带有签名
+	signed := &httpGetter{baseURL: srv.URL + defaultBasePath, secret: secret}
+	res := &pb.Response{}
+	if err := signed.Get(&pb.Request{Group: "auth", Key: "Tom"}, res); err != nil || string(res.GetValue()) != "Tom" {
+		t.Fatalf("failed to get with a signed request: %v", err)
+	}
+	if err := signed.Set(&pb.SetRequest{Group: "auth", Key: "Sam", Value: []byte("567")}, &pb.Response{}); err != nil {
+		t.Fatalf("failed to set with a signed request: %v", err)
+	}
+	batch := &pb.BatchResponse{}
+	if err := signed.GetMany(context.Background(), &pb.BatchRequest{Group: "auth", Keys: []string{"Sam"}}, batch); err != nil ||
+		string(batch.GetEntries()[0].GetValue()) != "567" {
+		t.Fatalf("failed to get many with a signed request: %v", err)
+	}
+
+	wrong := &httpGetter{baseURL: srv.URL + defaultBasePath, secret: []byte("wrong")}
+	err := wrong.Get(&pb.Request{Group: "auth", Key: "Tom"}, &pb.Response{})
+	if !errors.Is(err, ErrUnauthorized) || !errors.Is(err, ErrPeerUnavailable) {
+		t.Fatalf("wrong secret should be rejected, got %v", err)
+	}
+
+	//没有签名、时间戳过期的请求返回 401
+	stale := strconv.FormatInt(time.Now().Add(-2*maxClockSkew).Unix(), 10)
+	for name, header := range map[string]http.Header{
+		"unsigned": {},
+		"malformed": {
+			timestampHeader: {strconv.FormatInt(time.Now().Unix(), 10)},
+			signatureHeader: {"not hex"},
+		},
+		"stale": {
+			timestampHeader: {stale},
+			signatureHeader: {hex.EncodeToString(sign(secret, http.MethodGet, "/_geecache/auth/Tom", stale, nil))},
+		},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+defaultBasePath+"auth/Tom", nil)
+		req.Header = header
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusUnauthorized || res.Header.Get("WWW-Authenticate") != authScheme {
+			t.Fatalf("%s: expect 401 with WWW-Authenticate, got %d", name, res.StatusCode)
+		}
+	}
+	//签名之前先限制 body 的大小
+	limited := httptest.NewServer(NewHTTPPool("server", WithCache(c), WithSharedSecret(secret), WithMaxResponseSize(100)))
+	defer limited.Close()
+	big := &httpGetter{baseURL: limited.URL + defaultBasePath, secret: secret}
+	err = big.Set(&pb.SetRequest{Group: "auth", Key: "big", Value: make([]byte, 100+requestOverhead)}, &pb.Response{})
+	if err == nil || !strings.Contains(err.Error(), "413") {
+		t.Fatalf("oversized body should be rejected with 413, got %v", err)
+	}
+	if err := big.Set(&pb.SetRequest{Group: "auth", Key: "small", Value: make([]byte, 100)}, &pb.Response{}); err != nil {
+		t.Fatalf("failed to set a value within the limit: %v", err)
+	}
+
+	adminRes, err := http.Get(srv.URL + defaultAdminPath + "groups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminRes.Body.Close()
+	if adminRes.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("admin endpoint should require a signature, got %d", adminRes.StatusCode)
+	}
+}
+
+func TestHTTPPoolPicker(t *testing.T) {
+	peers := []string{"http://node1", "http://node2", "http://node3"}
+	for _, name := range []string{"ring", "rendezvous", "jump", "maglev"} {
+		newPicker, ok := PickerByName(name)
+		if !ok {
+			t.Fatalf("picker %s should be built in", name)
+		}
+		pool := NewHTTPPool("http://node1", WithPicker(newPicker), WithPeerRetries(0))
+		pool.Set(peers...)
+		want := newPicker()
+		want.Add(peers...)
+		for i := 0; i < 100; i++ {
+			key := strconv.Itoa(i)
+			owner := want.Get(key)
+			peer, ok := pool.PickPeer(key)
+			if ok != (owner != "http://node1") || ok && peer.(*httpGetter).baseURL != owner+defaultBasePath {
+				t.Fatalf("%s: %s should belong to %s, got %v", name, key, owner, peer)
+			}
+		}
+	}
+	if _, ok := PickerByName("unknown"); ok {
+		t.Fatalf("unknown picker should not be found")
+	}
+}
+
+func TestHTTPPoolLoadBalance(t *testing.T) {
+	//权重为 3 的节点分到大约 3 倍的 key
+	pool := NewHTTPPool("http://self", WithPeerWeights(map[string]int{"http://node2": 3}), WithPeerRetries(0))
+	pool.Set("http://node1", "http://node2")
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		peer, _ := pool.PickPeer(strconv.Itoa(i))
+		counts[peer.(*httpGetter).baseURL]++
+	}
+	if ratio := float64(counts["http://node2"+defaultBasePath]) / float64(counts["http://node1"+defaultBasePath]); ratio < 2 || ratio > 4 {
+		t.Fatalf("node2 should get about 3 times the keys of node1, got %v", counts)
+	}
+
+	//有界负载：正在处理的请求超过上限时，热点 key 溢出到其他节点
+	release := make(chan struct{})
+	var mutex sync.Mutex
+	hits := make(map[string]int)
+	//没有溢出标记的请求，只应该发给所属节点
+	owners := make(map[string]int)
+	var urls []string
+	for i := 0; i < 3; i++ {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			hits[r.Host]++
+			if r.Header.Get(overflowHeader) == "" {
+				owners[r.Host]++
+			}
+			mutex.Unlock()
+			<-release
+			body, _ := proto.Marshal(&pb.Response{Value: []byte("v")})
+			w.Write(body)
+		}))
+		defer srv.Close()
+		urls = append(urls, srv.URL)
+	}
+	pool = NewHTTPPool("http://self", WithLoadFactor(1.25), WithPeerRetries(0))
+	pool.Set(urls...)
+	ring := pool.peers.(*consistenthash.Map)
+	load := func() (total int, max int) {
+		pool.mutex.Lock()
+		defer pool.mutex.Unlock()
+		for _, u := range urls {
+			total += ring.Load(u)
+			if ring.Load(u) > max {
+				max = ring.Load(u)
+			}
+		}
+		return total, max
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		peer, ok := pool.PickPeer("hot")
+		if !ok {
+			t.Fatalf("hot should belong to a remote peer")
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			peer.Get(&pb.Request{Group: "g", Key: "hot"}, &pb.Response{})
+		}()
+		//等待请求开始，PickPeer 才能看到新的负载
+		for total, _ := load(); total != i+1; total, _ = load() {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if _, max := load(); max > 5 {
+		t.Fatalf("no peer should handle more than ceil(1.25*12/3) requests, got %d", max)
+	}
+	close(release)
+	wg.Wait()
+	if total, _ := load(); total != 0 {
+		t.Fatalf("finished requests should be released, %d still counted", total)
+	}
+	if len(hits) != 3 {
+		t.Fatalf("hot key should spill over to all peers, got %v", hits)
+	}
+	if len(owners) != 1 {
+		t.Fatalf("requests to peers other than the owner should be marked as overflow, got %v", owners)
+	}
+
+	//溢出的请求在收到的节点本地回源，不会再转发给所属节点
+	peer := &fakePeer{}
+	c := New()
+	var local int32
+	c.NewGroup("overflow", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&local, 1)
+		return []byte(key), nil
+	}))
+	c.RegisterPeers(peer)
+	srv := httptest.NewServer(NewHTTPPool("server", WithCache(c)))
+	defer srv.Close()
+	h := &httpGetter{baseURL: srv.URL + defaultBasePath, overflow: true}
+	out := &pb.Response{}
+	if err := h.Get(&pb.Request{Group: "overflow", Key: "remote1"}, out); err != nil || string(out.Value) != "remote1" {
+		t.Fatalf("overflow request should be served, got %q %v", out.Value, err)
+	}
+	if peer.gets != 0 || local != 1 {
+		t.Fatalf("overflow request should be loaded locally, got %d peer gets and %d local loads", peer.gets, local)
+	}
+}
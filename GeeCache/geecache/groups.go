@@ -0,0 +1,145 @@
+package geecache
+
+import (
+	"sort"
+	"sync"
+)
+
+// 一组 Group 和它们共用的节点池
+// 不同的 Cache 之间互不影响，一个进程中可以运行多个独立的缓存集群，测试之间也不会共享状态。
+// 包级别的 NewGroup、GetGroup 等函数使用默认的 Cache
+type Cache struct {
+	mu     sync.RWMutex
+	groups map[string]*Group
+	//RegisterPeers 注册的节点池，之后创建的 Group 都使用它
+	peers PeerPicker
+}
+
+// 创建一个空的 Cache
+func New() *Cache {
+	return &Cache{groups: make(map[string]*Group)}
+}
+
+// 包级别函数使用的 Cache
+var defaultCache = New()
+
+// 返回包级别函数使用的 Cache
+func DefaultCache() *Cache {
+	return defaultCache
+}
+
+// 在 Cache 中创建 Group，同名的 Group 已经存在时 panic，需要替换时使用 ReplaceGroup
+func (c *Cache) NewGroup(name string, bytes int64, getter Getter, opts ...GroupOption) *Group {
+	g := newGroup(name, bytes, getter, opts...)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, dup := c.groups[name]; dup {
+		g.close()
+		panic("geecache: NewGroup called twice for group " + name)
+	}
+	g.peers = c.peers
+	c.groups[name] = g
+	return g
+}
+
+// 创建新的 Group 并替换同名的 Group，旧的 Group 被销毁，不存在时和 NewGroup 一样
+func (c *Cache) ReplaceGroup(name string, bytes int64, getter Getter, opts ...GroupOption) *Group {
+	g := newGroup(name, bytes, getter, opts...)
+	c.mu.Lock()
+	g.peers = c.peers
+	old := c.groups[name]
+	c.groups[name] = g
+	c.mu.Unlock()
+	if old != nil {
+		old.close()
+	}
+	return g
+}
+
+func (c *Cache) GetGroup(name string) *Group {
+	//只读锁
+	c.mu.RLock()
+	g := c.groups[name]
+	c.mu.RUnlock()
+	return g
+}
+
+// 按名字排序的所有 Group 的名字
+func (c *Cache) ListGroups() []string {
+	c.mu.RLock()
+	names := make([]string, 0, len(c.groups))
+	for name := range c.groups {
+		names = append(names, name)
+	}
+	c.mu.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// 销毁 Group：从 Cache 中删除，停止后台清理，释放缓存占用的内存，之后不再访问远程节点
+// 仍然持有这个 Group 的调用者会收到 ErrGroupClosed；Group 不存在时返回 false
+// WithDiskTier 传入的 disk.Store 由调用者关闭
+func (c *Cache) DestroyGroup(name string) bool {
+	c.mu.Lock()
+	g, ok := c.groups[name]
+	delete(c.groups, name)
+	c.mu.Unlock()
+	if ok {
+		g.close()
+	}
+	return ok
+}
+
+// 注册 Cache 中所有 Group 共用的节点池，已经存在且还没有节点池的 Group 也会使用它
+// 节点池通过 WithCache 或 WithGRPCCache 使用同一个 Cache 查找 Group
+func (c *Cache) RegisterPeers(peers PeerPicker) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.peers != nil {
+		panic("RegisterPeers called more than once")
+	}
+	c.peers = peers
+	//Group 可能正在使用，通过 setPeers 加锁设置
+	for _, g := range c.groups {
+		g.setPeers(peers)
+	}
+}
+
+// 按名字排序的所有 Group
+func (c *Cache) sortedGroups() []*Group {
+	c.mu.RLock()
+	list := make([]*Group, 0, len(c.groups))
+	for _, g := range c.groups {
+		list = append(list, g)
+	}
+	c.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].name < list[j].name
+	})
+	return list
+}
+
+// 一个Group可以认为是一个缓存的命名空间
+// 在默认的 Cache 中创建，同名的 Group 已经存在时 panic，需要替换时使用 ReplaceGroup
+func NewGroup(name string, bytes int64, getter Getter, opts ...GroupOption) *Group {
+	return defaultCache.NewGroup(name, bytes, getter, opts...)
+}
+
+// 在默认的 Cache 中替换同名的 Group
+func ReplaceGroup(name string, bytes int64, getter Getter, opts ...GroupOption) *Group {
+	return defaultCache.ReplaceGroup(name, bytes, getter, opts...)
+}
+
+func GetGroup(name string) *Group {
+	return defaultCache.GetGroup(name)
+}
+
+// 默认的 Cache 中所有 Group 的名字
+func ListGroups() []string {
+	return defaultCache.ListGroups()
+}
+
+// 销毁默认的 Cache 中的 Group
+func DestroyGroup(name string) bool {
+	return defaultCache.DestroyGroup(name)
+}
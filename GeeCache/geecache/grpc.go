@@ -0,0 +1,458 @@
+package geecache
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"geecache/consistenthash"
+	pb "geecache/geecachepb"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// GetStream 每个消息中 value 的最大长度，远小于 gRPC 默认 4MB 的消息大小上限
+const grpcChunkSize = 64 << 10
+
+// 基于 gRPC 的节点池，和 HTTPPool 作用相同，可以互相替换。
+// 既实现了 PeerPicker，也实现了 geecachepb 中生成的 GroupCacheServer。
+// 每个远程节点只建立一条 HTTP/2 连接，所有请求在这条连接上多路复用。
+type GRPCPool struct {
+	pb.UnimplementedGroupCacheServer
+	//自己的地址，例如 localhost:8001
+	self  string
+	mutex sync.Mutex
+	//一致性哈希的map，用来根据具体的key选择节点
+	peers consistenthash.Picker
+	//创建 peers 的函数，默认使用哈希环
+	newPicker func() consistenthash.Picker
+	//映射远程节点与对应的 grpcGetter
+	grpcGetters map[string]*grpcGetter
+	//访问远程节点的超时时间，调用者的 ctx 没有设置截止时间时生效
+	timeout    time.Duration
+	dialOpts   []grpc.DialOption
+	serverOpts []grpc.ServerOption
+	//远程节点返回的值的大小上限，0 表示不限制
+	maxResponseSize int64
+	//查找 Group 的 Cache，默认是包级别函数使用的 Cache
+	cache *Cache
+}
+
+// 创建 GRPCPool 时的可选配置
+type GRPCPoolOption func(*GRPCPool)
+
+// 设置访问远程节点的超时时间，0 表示不设置超时
+func WithGRPCTimeout(d time.Duration) GRPCPoolOption {
+	return func(p *GRPCPool) {
+		p.timeout = d
+	}
+}
+
+// 追加建立连接时的 grpc.DialOption，默认使用明文连接
+func WithDialOptions(opts ...grpc.DialOption) GRPCPoolOption {
+	return func(p *GRPCPool) {
+		p.dialOpts = append(p.dialOpts, opts...)
+	}
+}
+
+// 节点之间通过 mTLS 通信，和 HTTPPool 的 WithMutualTLS 相同：cert 是本节点的证书，roots 用来校验其他节点的证书
+// Serve 要求对方出示可信的证书，连接其他节点时也校验对方的证书
+func WithGRPCMutualTLS(cert tls.Certificate, roots *x509.CertPool) GRPCPoolOption {
+	return func(p *GRPCPool) {
+		server := &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientCAs:    roots,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			MinVersion:   tls.VersionTLS12,
+		}
+		client := &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      roots,
+			MinVersion:   tls.VersionTLS12,
+		}
+		p.serverOpts = append(p.serverOpts, grpc.Creds(credentials.NewTLS(server)))
+		//后面的 DialOption 覆盖默认的明文连接
+		p.dialOpts = append(p.dialOpts, grpc.WithTransportCredentials(credentials.NewTLS(client)))
+	}
+}
+
+// 设置远程节点返回的值的大小上限，超过上限时返回 ErrValueTooLarge，0 表示不限制
+func WithGRPCMaxResponseSize(n int64) GRPCPoolOption {
+	return func(p *GRPCPool) {
+		p.maxResponseSize = n
+	}
+}
+
+// 设置选择节点的算法，所有节点必须使用同样的算法
+func WithGRPCPicker(newPicker func() consistenthash.Picker) GRPCPoolOption {
+	return func(p *GRPCPool) {
+		p.newPicker = newPicker
+	}
+}
+
+// 设置处理请求时查找 Group 的 Cache
+func WithGRPCCache(c *Cache) GRPCPoolOption {
+	return func(p *GRPCPool) {
+		p.cache = c
+	}
+}
+
+// 客户端类，持有到远程节点的连接
+type grpcGetter struct {
+	addr    string
+	conn    *grpc.ClientConn
+	client  pb.GroupCacheClient
+	timeout time.Duration
+	//响应的大小上限，0 表示不限制
+	maxBytes int64
+}
+
+func NewGRPCPool(self string, opts ...GRPCPoolOption) *GRPCPool {
+	p := &GRPCPool{
+		self:      self,
+		timeout:   defaultPeerTimeout,
+		dialOpts:  []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())},
+		cache:     defaultCache,
+		newPicker: newRing,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+func (p *GRPCPool) Log(format string, v ...interface{}) {
+	log.Printf("[Server %s] %s", p.self, fmt.Sprintf(format, v...))
+}
+
+// 设置节点，仍然在列表中的节点继续使用原来的连接，其余的连接会被关闭
+// 名字不叫 Set 是因为 GroupCacheServer 已经有了 Set 方法
+func (p *GRPCPool) SetPeers(peers ...string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	getters := make(map[string]*grpcGetter, len(peers))
+	for _, peer := range peers {
+		if peer == p.self {
+			continue
+		}
+		if g, ok := p.grpcGetters[peer]; ok {
+			getters[peer] = g
+			continue
+		}
+		//grpc.Dial 不会阻塞，连接在第一次请求时建立，断开后自动重连
+		conn, err := grpc.Dial(peer, p.dialOpts...)
+		if err != nil {
+			for addr, g := range getters {
+				if p.grpcGetters[addr] != g {
+					g.conn.Close()
+				}
+			}
+			return fmt.Errorf("dial %s: %v", peer, err)
+		}
+		getters[peer] = &grpcGetter{
+			addr:     peer,
+			conn:     conn,
+			client:   pb.NewGroupCacheClient(conn),
+			timeout:  p.timeout,
+			maxBytes: p.maxResponseSize,
+		}
+	}
+	for addr, g := range p.grpcGetters {
+		if getters[addr] != g {
+			g.conn.Close()
+		}
+	}
+	p.peers = p.newPicker()
+	p.peers.Add(peers...)
+	p.grpcGetters = getters
+	return nil
+}
+
+// 根据具体的key选择对应的节点
+func (p *GRPCPool) PickPeer(key string) (PeerGetter, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.peers == nil {
+		return nil, false
+	}
+	peer := p.peers.Get(key)
+	if peer != "" && peer != p.self {
+		p.Log("pick peer %s", peer)
+		return p.grpcGetters[peer], true
+	}
+	return nil, false
+}
+
+// 从 key 所在的位置顺时针选择 n 个节点，自己用 nil 表示
+func (p *GRPCPool) PickReplicas(key string, n int) []PeerGetter {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.peers == nil || n <= 0 {
+		return nil
+	}
+	owners := make([]PeerGetter, 0, n)
+	p.peers.Walk(key, func(peer string) bool {
+		if peer == p.self {
+			owners = append(owners, nil)
+		} else {
+			owners = append(owners, p.grpcGetters[peer])
+		}
+		return len(owners) < n
+	})
+	return owners
+}
+
+// 返回除自己以外的所有节点
+func (p *GRPCPool) AllPeers() []PeerGetter {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	peers := make([]PeerGetter, 0, len(p.grpcGetters))
+	for _, getter := range p.grpcGetters {
+		peers = append(peers, getter)
+	}
+	return peers
+}
+
+// 关闭到所有远程节点的连接
+func (p *GRPCPool) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, g := range p.grpcGetters {
+		g.conn.Close()
+	}
+	p.grpcGetters = nil
+	p.peers = nil
+	return nil
+}
+
+// 在 lis 上启动 gRPC 服务，直到 lis 被关闭
+func (p *GRPCPool) Serve(lis net.Listener) error {
+	s := grpc.NewServer(p.serverOpts...)
+	pb.RegisterGroupCacheServer(s, p)
+	p.Log("grpc serving at %s", lis.Addr())
+	return s.Serve(lis)
+}
+
+var _ PeerPicker = (*GRPCPool)(nil)
+var _ PeerLister = (*GRPCPool)(nil)
+var _ ReplicaPicker = (*GRPCPool)(nil)
+var _ pb.GroupCacheServer = (*GRPCPool)(nil)
+
+// 查找请求对应的分组
+func (p *GRPCPool) group(name string) (*Group, error) {
+	group := p.cache.GetGroup(name)
+	if group == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "no such group: %s", name)
+	}
+	group.stats.serverRequests.Add(1)
+	return group, nil
+}
+
+// 服务端：查找缓存值
+func (p *GRPCPool) Get(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	p.Log("Get %s/%s", in.GetGroup(), in.GetKey())
+	group, err := p.group(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	view, err := group.getContext(ctx, in.GetKey())
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	view = group.encode(view)
+	res := &pb.Response{Value: view.ByteSlice(), Encoding: group.wireEncoding(view)}
+	if !view.Expire().IsZero() {
+		res.Expire = view.Expire().UnixNano()
+	}
+	return res, nil
+}
+
+// 服务端：和 Get 相同，值超过 grpcChunkSize 时分成多个消息发送
+func (p *GRPCPool) GetStream(in *pb.Request, stream pb.GroupCache_GetStreamServer) error {
+	res, err := p.Get(stream.Context(), in)
+	if err != nil {
+		return err
+	}
+	value := res.GetValue()
+	for {
+		n := len(value)
+		if n > grpcChunkSize {
+			n = grpcChunkSize
+		}
+		res.Value, value = value[:n], value[n:]
+		if err = stream.Send(res); err != nil {
+			return err
+		}
+		if len(value) == 0 {
+			return nil
+		}
+		//后面的消息只带 value
+		res = &pb.Response{}
+	}
+}
+
+// 服务端：写入本节点的缓存
+func (p *GRPCPool) Set(ctx context.Context, in *pb.SetRequest) (*pb.Response, error) {
+	p.Log("Set %s/%s", in.GetGroup(), in.GetKey())
+	group, err := p.group(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	var expire time.Time
+	if in.GetExpire() != 0 {
+		expire = time.Unix(0, in.GetExpire())
+	}
+	if err = group.populateCache(in.GetKey(), ByteView{b: in.GetValue()}, expire); err != nil {
+		return nil, grpcStatus(err)
+	}
+	return &pb.Response{}, nil
+}
+
+// 服务端：只删除本节点上的缓存值，不再继续转发
+func (p *GRPCPool) Remove(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	p.Log("Remove %s/%s", in.GetGroup(), in.GetKey())
+	group, err := p.group(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	group.removeLocally(in.GetKey())
+	return &pb.Response{}, nil
+}
+
+// 服务端：批量查找缓存值，每个 key 的错误放在对应的 BatchEntry 中
+func (p *GRPCPool) GetMany(ctx context.Context, in *pb.BatchRequest) (*pb.BatchResponse, error) {
+	p.Log("GetMany %s %d keys", in.GetGroup(), len(in.GetKeys()))
+	group, err := p.group(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	values, errs := group.getMany(ctx, in.GetKeys())
+	return group.toBatchResponse(in.GetKeys(), values, errs), nil
+}
+
+// 把 Group 返回的错误转换成 gRPC 状态码，和 HTTPStatus 对应
+func grpcStatus(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, ErrEmptyKey):
+		code = codes.InvalidArgument
+	case errors.Is(err, ErrNotFound):
+		code = codes.NotFound
+	case errors.Is(err, ErrValueTooLarge):
+		code = codes.ResourceExhausted
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, ErrPeerUnavailable), errors.Is(err, ErrGroupClosed):
+		code = codes.Unavailable
+	}
+	return status.Error(code, err.Error())
+}
+
+// 把远程节点返回的 gRPC 状态还原成对应的错误
+func fromGRPCStatus(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return &peerError{err: err}
+	}
+	switch st.Code() {
+	case codes.NotFound:
+		return fmt.Errorf("%w: %s", ErrNotFound, st.Message())
+	case codes.ResourceExhausted:
+		return fmt.Errorf("%w: %s", ErrValueTooLarge, st.Message())
+	case codes.Internal:
+		return &loaderError{err: errors.New(st.Message())}
+	case codes.DeadlineExceeded:
+		return &peerError{err: context.DeadlineExceeded}
+	case codes.Canceled:
+		return &peerError{err: context.Canceled}
+	}
+	return &peerError{err: err}
+}
+
+// 调用者没有设置截止时间时加上默认的超时
+func (g *grpcGetter) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || g.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, g.timeout)
+}
+
+func (g *grpcGetter) Get(in *pb.Request, out *pb.Response) error {
+	return g.GetContext(context.Background(), in, out)
+}
+
+func (g *grpcGetter) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	ctx, cancel := g.withTimeout(ctx)
+	defer cancel()
+	stream, err := g.client.GetStream(ctx, in)
+	if err != nil {
+		return fromGRPCStatus(err)
+	}
+	//按收到的数据拼接，超过上限时立即停止，不需要对方声明总长度
+	var value []byte
+	for first := true; ; first = false {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fromGRPCStatus(err)
+		}
+		if first {
+			out.Expire = res.GetExpire()
+			out.Encoding = res.GetEncoding()
+			value = res.GetValue()
+		} else {
+			value = append(value, res.GetValue()...)
+		}
+		if g.maxBytes > 0 && int64(len(value)) > g.maxBytes {
+			return fmt.Errorf("%w: response has more than %d bytes", ErrValueTooLarge, g.maxBytes)
+		}
+	}
+	out.Value = value
+	return nil
+}
+
+func (g *grpcGetter) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	ctx, cancel := g.withTimeout(ctx)
+	defer cancel()
+	res, err := g.client.GetMany(ctx, in)
+	if err != nil {
+		return fromGRPCStatus(err)
+	}
+	out.Entries = res.GetEntries()
+	return nil
+}
+
+func (g *grpcGetter) Set(in *pb.SetRequest, out *pb.Response) error {
+	ctx, cancel := g.withTimeout(context.Background())
+	defer cancel()
+	if _, err := g.client.Set(ctx, in); err != nil {
+		return fromGRPCStatus(err)
+	}
+	return nil
+}
+
+func (g *grpcGetter) Remove(in *pb.Request, out *pb.Response) error {
+	ctx, cancel := g.withTimeout(context.Background())
+	defer cancel()
+	if _, err := g.client.Remove(ctx, in); err != nil {
+		return fromGRPCStatus(err)
+	}
+	return nil
+}
+
+var _ ContextPeerGetter = (*grpcGetter)(nil)
+var _ BatchPeerGetter = (*grpcGetter)(nil)
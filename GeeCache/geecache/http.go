@@ -1,21 +1,48 @@
 package geecache
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"geecache/consistenthash"
 	pb "geecache/geecachepb"
 	"github.com/golang/protobuf/proto"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
-	defaultBasePath = "/_geecache/"
-	defaultReplicas = 50
+	defaultBasePath    = "/_geecache/"
+	defaultMetricsPath = "/metrics"
+	defaultAdminPath   = "/_geecache_admin/"
+	defaultReplicas    = 50
+	//访问远程节点的默认超时时间
+	defaultPeerTimeout = 3 * time.Second
+	//所属节点失败之后，顺时针再尝试几个节点
+	defaultPeerRetries = 1
+	//健康检查的地址，位于 basePath 之下，例如 /_geecache/health
+	healthPath = "health"
+	//超过这个大小的值直接写入响应，不再编码成 pb.Response
+	defaultStreamThreshold = 64 << 10
+	//直接写入的值使用的 Content-Type，过期时间和压缩算法放在响应头中
+	rawContentType = "application/x-geecache-value"
+	expireHeader   = "X-Geecache-Expire"
+	encodingHeader = "X-Geecache-Encoding"
+	//有界负载下溢出到非所属节点的读请求，收到的节点直接在本地回源，不再转发给所属节点
+	overflowHeader = "X-Geecache-Overflow"
+	//没有设置 WithMaxResponseSize 时请求 body 的大小上限
+	defaultMaxRequestSize = 64 << 20
+	//请求 body 在值之外还有分组名、key 等字段，上限比值的上限多出这么多
+	requestOverhead = 64 << 10
 )
 
 // 服务端类
@@ -26,12 +53,135 @@ type HTTPPool struct {
 	self string
 	//作为节点间通讯地址的前缀
 	basePath string
-	mutex    sync.Mutex
+	//Prometheus 抓取统计信息的地址
+	metricsPath string
+	//运行时管理节点的地址前缀
+	adminPath string
+	mutex     sync.Mutex
 	//一致性哈希的map，用来根据具体的key选择节点
-	peers *consistenthash.Map
+	peers consistenthash.Picker
+	//创建 peers 的函数，默认使用哈希环
+	newPicker func() consistenthash.Picker
 	//映射远程节点与对应的 httpGetter
 	//每一个远程节点对应一个 httpGetter，因为 httpGetter 与远程节点的地址 baseURL 有关
 	httpGetters map[string]*httpGetter
+	//所有 httpGetter 共用的 http 客户端
+	client *http.Client
+	//熔断器的配置，failureThreshold <= 0 表示不熔断
+	failureThreshold int
+	breakerCooldown  time.Duration
+	//所属节点失败之后重试的节点个数
+	retries int
+	//超过这个大小的值不编码成 pb.Response，直接写入响应
+	streamThreshold int
+	//远程节点返回的值的大小上限，0 表示不限制
+	maxResponseSize int64
+	//查找 Group 的 Cache，默认是包级别函数使用的 Cache
+	cache *Cache
+	//服务端的 TLS 配置，不为 nil 时要求请求方出示可信的证书
+	tlsConfig *tls.Config
+	//节点之间共享的签名密钥，nil 表示不签名
+	secret []byte
+	//节点的权重，没有设置的节点权重为 1，Picker 实现了 WeightedPicker 时生效
+	weights map[string]int
+	//有界负载的负载因子，0 表示不启用，Picker 实现了 LoadBalancer 时生效
+	loadFactor float64
+}
+
+// 创建 HTTPPool 时的可选配置
+type HTTPPoolOption func(*HTTPPool)
+
+// 设置访问远程节点的超时时间，0 表示不设置超时
+func WithPeerTimeout(d time.Duration) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.client.Timeout = d
+	}
+}
+
+// 设置熔断器：连续失败 threshold 次之后熔断，cooldown 之后开始探测节点是否恢复
+// threshold <= 0 表示不熔断
+func WithCircuitBreaker(threshold int, cooldown time.Duration) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.failureThreshold = threshold
+		p.breakerCooldown = cooldown
+	}
+}
+
+// 设置所属节点不可用时，顺时针再尝试几个节点，0 表示直接回退到本地
+func WithPeerRetries(n int) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.retries = n
+	}
+}
+
+// 设置直接写入响应的值的大小下限，避免为大值额外编码一次
+// 只有服务端流式发送，请求方仍然把整个值读入内存，内存占用由 WithMaxResponseSize 限制
+func WithStreamThreshold(n int) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.streamThreshold = n
+	}
+}
+
+// 设置远程节点返回的值的大小上限，超过上限时返回 ErrValueTooLarge，0 表示不限制
+func WithMaxResponseSize(n int64) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.maxResponseSize = n
+	}
+}
+
+// 设置节点的权重，权重为 2 的节点分到的 key 大约是权重为 1 的节点的 2 倍
+// 所有节点必须使用同样的权重，Picker 需要实现 consistenthash.WeightedPicker，默认的哈希环支持
+func WithPeerWeights(weights map[string]int) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.weights = make(map[string]int, len(weights))
+		for peer, w := range weights {
+			p.weights[peer] = w
+		}
+	}
+}
+
+// 启用有界负载：每个节点正在处理的请求数不超过平均值的 factor 倍，热点 key 的请求溢出到下一个节点
+// 溢出的请求带有 X-Geecache-Overflow，收到的节点在本地回源，不会再转发回所属节点
+// 负载只统计本节点发出、还没有完成的请求，各节点分别判断，不需要互相同步
+// factor 必须大于 1，Picker 需要实现 consistenthash.LoadBalancer，默认的哈希环支持
+func WithLoadFactor(factor float64) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.loadFactor = factor
+	}
+}
+
+// 设置选择节点的算法，所有节点必须使用同样的算法，可以用 PickerByName 按名字选择
+func WithPicker(newPicker func() consistenthash.Picker) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.newPicker = newPicker
+	}
+}
+
+// 内置的选择节点的算法，可以按名字选择
+var pickers = map[string]func() consistenthash.Picker{
+	"ring":       newRing,
+	"ring64":     func() consistenthash.Picker { return consistenthash.New64(defaultReplicas, nil) },
+	"rendezvous": func() consistenthash.Picker { return consistenthash.NewRendezvous(nil) },
+	"jump":       func() consistenthash.Picker { return consistenthash.NewJump(nil) },
+	"maglev":     func() consistenthash.Picker { return consistenthash.NewMaglev(0, nil) },
+}
+
+// 根据名字查找内置的选择节点的算法：ring、ring64、rendezvous、jump 或 maglev
+func PickerByName(name string) (func() consistenthash.Picker, bool) {
+	f, ok := pickers[name]
+	return f, ok
+}
+
+// 默认的哈希环
+func newRing() consistenthash.Picker {
+	return consistenthash.New(defaultReplicas, nil)
+}
+
+// 设置处理请求时查找 Group 的 Cache，同一个进程中的多个节点各自使用自己的 Cache
+func WithCache(c *Cache) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.cache = c
+	}
 }
 
 // 客户端类
@@ -39,13 +189,38 @@ type httpGetter struct {
 	//表示将要访问的远程节点的地址
 	//例如 http://example.com/_geecache/
 	baseURL string
+	client  *http.Client
+	//为 nil 时不熔断
+	breaker *breaker
+	//响应的大小上限，0 表示不限制
+	maxBytes int64
+	//请求的签名密钥，nil 表示不签名
+	secret []byte
+	//启用有界负载时记录节点正在处理的请求数，delta 为 1 表示开始，-1 表示结束，nil 表示不记录
+	track func(delta int)
+	//为 true 时请求带有 overflowHeader，对方在本地处理
+	overflow bool
 }
 
-func NewHTTPPool(self string) *HTTPPool {
-	return &HTTPPool{
-		self:     self,
-		basePath: defaultBasePath,
+func NewHTTPPool(self string, opts ...HTTPPoolOption) *HTTPPool {
+	p := &HTTPPool{
+		self:        self,
+		basePath:    defaultBasePath,
+		metricsPath: defaultMetricsPath,
+		adminPath:   defaultAdminPath,
+		client:      &http.Client{Timeout: defaultPeerTimeout},
+
+		failureThreshold: defaultFailureThreshold,
+		breakerCooldown:  defaultBreakerCooldown,
+		retries:          defaultPeerRetries,
+		streamThreshold:  defaultStreamThreshold,
+		cache:            defaultCache,
+		newPicker:        newRing,
+	}
+	for _, opt := range opts {
+		opt(p)
 	}
+	return p
 }
 
 func (p *HTTPPool) Log(format string, v ...interface{}) {
@@ -54,6 +229,24 @@ func (p *HTTPPool) Log(format string, v ...interface{}) {
 
 // 服务端的实现逻辑
 func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == p.metricsPath {
+		serveMetrics(w, r, p.cache)
+		return
+	}
+	//健康检查，其他节点用来判断熔断之后是否恢复
+	if r.URL.Path == p.basePath+healthPath {
+		w.Write([]byte("ok"))
+		return
+	}
+	//签名校验和 PUT、POST 都要读取整个 body，超过上限时停止读取
+	r.Body = http.MaxBytesReader(w, r.Body, p.maxRequestSize())
+	if !p.authenticate(w, r) {
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, p.adminPath) {
+		p.serveAdmin(w, r)
+		return
+	}
 	//首先检查访问的路由是否有规定前缀
 	if !strings.HasPrefix(r.URL.Path, p.basePath) {
 		panic("HTTPPool serving unexpected path: " + r.URL.Path)
@@ -72,20 +265,45 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	key := parts[1]
 	fmt.Println(groupName, key)
 	//查找分组
-	group := GetGroup(groupName)
+	group := p.cache.GetGroup(groupName)
 	if group == nil {
 		http.Error(w, "no such group:"+groupName, http.StatusBadRequest)
 		return
 	}
-	//查找内容
-	view, err := group.Get(key)
+	group.stats.serverRequests.Add(1)
+	switch r.Method {
+	case http.MethodPost:
+		//批量获取，路径中不带 key：/<basepath>/<groupname>/
+		p.serveGetMany(w, r, group)
+		return
+	case http.MethodPut:
+		p.serveSet(w, r, group, key)
+		return
+	case http.MethodDelete:
+		//只删除本节点上的缓存值，不再继续转发，避免在节点之间循环
+		group.removeLocally(key)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	//查找内容，请求方断开连接时 r.Context() 会被取消
+	//mainCache 中压缩过的值直接发送，由请求方解压
+	view, err := group.getContext(overflowContext(r), key)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), HTTPStatus(err))
+		return
+	}
+	view = group.encode(view)
+	if view.Len() >= p.streamThreshold {
+		p.writeRaw(w, group, view)
 		return
 	}
 	//将值作为原型消息写入响应主体
 	//编码Http响应
-	body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice()})
+	res := &pb.Response{Value: view.ByteSlice(), Encoding: group.wireEncoding(view)}
+	if !view.Expire().IsZero() {
+		res.Expire = view.Expire().UnixNano()
+	}
+	body, err := proto.Marshal(res)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -96,63 +314,543 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Write(body)
 }
 
+// 溢出的请求在本地回源，避免所属节点已满时请求又被转发回去
+func overflowContext(r *http.Request) context.Context {
+	if r.Header.Get(overflowHeader) != "" {
+		return withLocalLoad(r.Context())
+	}
+	return r.Context()
+}
+
+// 请求 body 的大小上限，由值的大小上限决定
+func (p *HTTPPool) maxRequestSize() int64 {
+	if p.maxResponseSize > 0 {
+		return p.maxResponseSize + requestOverhead
+	}
+	return defaultMaxRequestSize
+}
+
+// 读取请求 body 失败时的状态码，超过大小上限时返回 413
+func bodyStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// 大值直接写入响应，不复制也不编码，ByteView 是只读的，可以直接使用底层的切片
+func (p *HTTPPool) writeRaw(w http.ResponseWriter, group *Group, view ByteView) {
+	w.Header().Set("Content-Type", rawContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(view.Len()))
+	if !view.Expire().IsZero() {
+		w.Header().Set(expireHeader, strconv.FormatInt(view.Expire().UnixNano(), 10))
+	}
+	if enc := group.wireEncoding(view); enc != "" {
+		w.Header().Set(encodingHeader, enc)
+	}
+	w.Write(view.b)
+}
+
+// 处理 PUT 请求，body 是编码后的 pb.SetRequest
+func (p *HTTPPool) serveSet(w http.ResponseWriter, r *http.Request, group *Group, key string) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), bodyStatus(err))
+		return
+	}
+	req := &pb.SetRequest{}
+	if err = proto.Unmarshal(body, req); err != nil {
+		http.Error(w, "decoding request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var expire time.Time
+	if req.GetExpire() != 0 {
+		expire = time.Unix(0, req.GetExpire())
+	}
+	if err = group.populateCache(key, ByteView{b: req.GetValue()}, expire); err != nil {
+		http.Error(w, err.Error(), HTTPStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 处理 POST 请求，body 是编码后的 pb.BatchRequest
+func (p *HTTPPool) serveGetMany(w http.ResponseWriter, r *http.Request, group *Group) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), bodyStatus(err))
+		return
+	}
+	req := &pb.BatchRequest{}
+	if err = proto.Unmarshal(body, req); err != nil {
+		http.Error(w, "decoding request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	values, errs := group.getMany(overflowContext(r), req.GetKeys())
+	body, err = proto.Marshal(group.toBatchResponse(req.GetKeys(), values, errs))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
+// 用传入的节点替换现有的所有节点
 func (p *HTTPPool) Set(peers ...string) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 	//实例了一致性哈希算法
-	p.peers = consistenthash.New(defaultReplicas, nil)
-	//添加了传入的节点
-	p.peers.Add(peers...)
+	p.peers = p.newPeers()
 	p.httpGetters = make(map[string]*httpGetter, len(peers))
+	p.addPeers(peers...)
+}
+
+// 创建 Picker，并按配置启用有界负载
+func (p *HTTPPool) newPeers() consistenthash.Picker {
+	peers := p.newPicker()
+	if lb, ok := peers.(consistenthash.LoadBalancer); ok && p.loadFactor > 0 {
+		lb.SetLoadFactor(p.loadFactor)
+	}
+	return peers
+}
+
+// 增加节点，已经存在的节点会被忽略
+// 只有新节点的虚拟节点被加入哈希环，其他节点负责的 key 不受影响
+func (p *HTTPPool) AddPeers(peers ...string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.peers == nil {
+		p.peers = p.newPeers()
+		p.httpGetters = make(map[string]*httpGetter, len(peers))
+	}
+	p.addPeers(peers...)
+}
+
+func (p *HTTPPool) addPeers(peers ...string) {
+	added := make([]string, 0, len(peers))
 	//为每一个节点创建了一个http客户端
 	for _, peer := range peers {
-		p.httpGetters[peer] = &httpGetter{
-			baseURL: peer + p.basePath,
+		if _, ok := p.httpGetters[peer]; ok {
+			continue
+		}
+		h := &httpGetter{
+			baseURL:  peer + p.basePath,
+			client:   p.client,
+			maxBytes: p.maxResponseSize,
+			secret:   p.secret,
+		}
+		h.breaker = newBreaker(p.failureThreshold, p.breakerCooldown, h.health)
+		if _, ok := p.peers.(consistenthash.LoadBalancer); ok && p.loadFactor > 0 {
+			peer := peer
+			h.track = func(delta int) { p.track(peer, delta) }
+		}
+		p.httpGetters[peer] = h
+		added = append(added, peer)
+	}
+	//添加了传入的节点，设置了权重的节点按权重添加
+	wp, weighted := p.peers.(consistenthash.WeightedPicker)
+	for _, peer := range added {
+		if w, ok := p.weights[peer]; ok && weighted {
+			wp.AddWeighted(peer, w)
+		} else {
+			p.peers.Add(peer)
+		}
+	}
+}
+
+// 更新节点正在处理的请求数，Set 之后换了 Picker 也没有关系，Done 会忽略没有负载的节点
+func (p *HTTPPool) track(peer string, delta int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	lb, ok := p.peers.(consistenthash.LoadBalancer)
+	if !ok {
+		return
+	}
+	if delta > 0 {
+		lb.Inc(peer)
+	} else {
+		lb.Done(peer)
+	}
+}
+
+// 删除节点，它负责的 key 顺时针交给下一个节点
+func (p *HTTPPool) RemovePeers(peers ...string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.peers == nil {
+		return
+	}
+	removed := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		if _, ok := p.httpGetters[peer]; ok {
+			delete(p.httpGetters, peer)
+			removed = append(removed, peer)
 		}
 	}
+	p.peers.Remove(removed...)
+}
+
+// 返回所有节点（包括自己）的地址，按字典序排列
+func (p *HTTPPool) Peers() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	peers := make([]string, 0, len(p.httpGetters))
+	for peer := range p.httpGetters {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return peers
 }
 
 // 根据具体的key选择对应的节点
+// 从 key 所在的位置顺时针查找，跳过已经熔断的节点和有界负载下已经达到上限的节点，遇到自己时由本地处理
 func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
-	peer := p.peers.Get(key)
-	if peer != "" && peer != p.self {
-		p.Log("pick peer %s", peer)
+	if p.peers == nil {
+		return nil, false
+	}
+	lb, _ := p.peers.(consistenthash.LoadBalancer)
+	var getters []*httpGetter
+	//跳过了已满的节点，之后选中的节点都不是 key 的所属节点
+	overflow := false
+	p.peers.Walk(key, func(peer string) bool {
+		if peer == p.self {
+			return false
+		}
+		if lb != nil && lb.Full(peer) {
+			overflow = true
+			return true
+		}
+		if h := p.httpGetters[peer]; h.breaker.allow() {
+			if overflow {
+				o := *h
+				o.overflow = true
+				h = &o
+			}
+			getters = append(getters, h)
+		}
+		return len(getters) <= p.retries
+	})
+	switch len(getters) {
+	case 0:
+		return nil, false
+	case 1:
+		p.Log("pick peer %s", getters[0].baseURL)
 		//返回对应的分布式节点实例
-		return p.httpGetters[peer], true
+		return getters[0], true
+	}
+	p.Log("pick peer %s", getters[0].baseURL)
+	return &retryGetter{getters: getters}, true
+}
+
+// 从 key 所在的位置顺时针选择 n 个节点，跳过已经熔断的节点，自己用 nil 表示
+func (p *HTTPPool) PickReplicas(key string, n int) []PeerGetter {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.peers == nil || n <= 0 {
+		return nil
+	}
+	owners := make([]PeerGetter, 0, n)
+	p.peers.Walk(key, func(peer string) bool {
+		if peer == p.self {
+			owners = append(owners, nil)
+		} else if h := p.httpGetters[peer]; h.breaker.allow() {
+			owners = append(owners, h)
+		}
+		return len(owners) < n
+	})
+	return owners
+}
+
+// 返回除自己以外的所有节点
+func (p *HTTPPool) AllPeers() []PeerGetter {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	peers := make([]PeerGetter, 0, len(p.httpGetters))
+	for addr, getter := range p.httpGetters {
+		if addr != p.self {
+			peers = append(peers, getter)
+		}
 	}
-	return nil, false
+	return peers
 }
 
 var _ PeerPicker = (*HTTPPool)(nil)
+var _ PeerLister = (*HTTPPool)(nil)
+var _ ReplicaPicker = (*HTTPPool)(nil)
 
-func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
-	u := fmt.Sprintf(
+// 拼接访问远程节点上某个 key 的地址
+func (h *httpGetter) url(group, key string) string {
+	return fmt.Sprintf(
 		"%v%v/%v",
 		h.baseURL,
-		url.QueryEscape(in.GetGroup()),
-		url.QueryEscape(in.GetKey()),
+		url.QueryEscape(group),
+		url.QueryEscape(key),
 	)
-	//通过http的通信方式访问远程节点的地址并且获取返回值
-	res, err := http.Get(u)
+}
+
+func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
+	return h.GetContext(context.Background(), in, out)
+}
+
+func (h *httpGetter) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	if h.track != nil {
+		h.track(1)
+		defer h.track(-1)
+	}
+	err := h.get(ctx, in, out)
+	//调用者自己放弃的请求不能说明节点不可用
+	if ctx.Err() == nil {
+		h.breaker.record(err)
+	}
+	return err
+}
+
+func (h *httpGetter) get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	u := h.url(in.GetGroup(), in.GetKey())
+	req, err := h.newRequest(ctx, http.MethodGet, u, nil)
 	if err != nil {
 		return err
 	}
+	//通过http的通信方式访问远程节点的地址并且获取返回值
+	res, err := h.httpClient().Do(req)
+	if err != nil {
+		return &peerError{err: err}
+	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned: %v", res.Status)
+		return statusError(res)
+	}
+	if res.Header.Get("Content-Type") == rawContentType {
+		return h.readRaw(res, out)
 	}
 	//读取Body部分的所有内容
-	bytes, err := ioutil.ReadAll(res.Body)
+	bytes, err := h.readBody(res)
 	if err != nil {
-		return fmt.Errorf("reading response body: %v", err)
+		return err
 	}
 	//解码http响应
 	if err = proto.Unmarshal(bytes, out); err != nil {
-		return fmt.Errorf("decoding response body: %v", err)
+		return &peerError{err: fmt.Errorf("decoding response body: %v", err)}
+	}
+	return nil
+}
+
+// 通过 POST 请求批量获取缓存值
+func (h *httpGetter) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	if h.track != nil {
+		h.track(1)
+		defer h.track(-1)
+	}
+	err := h.getMany(ctx, in, out)
+	if ctx.Err() == nil {
+		h.breaker.record(err)
+	}
+	return err
+}
+
+func (h *httpGetter) getMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encoding request body: %v", err)
+	}
+	req, err := h.newRequest(ctx, http.MethodPost, h.url(in.GetGroup(), ""), body)
+	if err != nil {
+		return err
+	}
+	res, err := h.httpClient().Do(req)
+	if err != nil {
+		return &peerError{err: err}
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return statusError(res)
+	}
+	body, err = h.readBody(res)
+	if err != nil {
+		return err
+	}
+	if err = proto.Unmarshal(body, out); err != nil {
+		return &peerError{err: fmt.Errorf("decoding response body: %v", err)}
+	}
+	return nil
+}
+
+// 节点的地址，GetMany 用来把属于同一个节点的 key 分到一组
+func (h *httpGetter) addr() string {
+	return h.baseURL
+}
+
+// 读取响应，超过 maxBytes 时返回 ErrValueTooLarge
+func (h *httpGetter) readBody(res *http.Response) ([]byte, error) {
+	if h.maxBytes > 0 && res.ContentLength > h.maxBytes {
+		return nil, fmt.Errorf("%w: response has %d bytes", ErrValueTooLarge, res.ContentLength)
+	}
+	body := io.Reader(res.Body)
+	if h.maxBytes > 0 {
+		body = io.LimitReader(res.Body, h.maxBytes+1)
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, &peerError{err: fmt.Errorf("reading response body: %v", err)}
+	}
+	if h.maxBytes > 0 && int64(len(b)) > h.maxBytes {
+		return nil, fmt.Errorf("%w: response has more than %d bytes", ErrValueTooLarge, h.maxBytes)
+	}
+	return b, nil
+}
+
+// 读取直接写入响应的大值
+// 请求方不是流式的：值最终要放进 out.Value 和 ByteView，这里仍然把整个值读入内存，
+// 只是省去了 protobuf 解码；不按对方声明的 Content-Length 预先分配内存，超过 maxBytes 时立即停止读取
+func (h *httpGetter) readRaw(res *http.Response, out *pb.Response) error {
+	value, err := h.readBody(res)
+	if err != nil {
+		return err
+	}
+	out.Value = value
+	out.Encoding = res.Header.Get(encodingHeader)
+	if expire := res.Header.Get(expireHeader); expire != "" {
+		n, err := strconv.ParseInt(expire, 10, 64)
+		if err != nil {
+			return &peerError{err: fmt.Errorf("decoding %s: %v", expireHeader, err)}
+		}
+		out.Expire = n
 	}
 	return nil
 }
 
-var _ PeerGetter = (*httpGetter)(nil)
+// 把远程节点返回的状态码还原成对应的错误
+func statusError(res *http.Response) error {
+	msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+	err := fmt.Errorf("server returned: %v: %s", res.Status, strings.TrimSpace(string(msg)))
+	switch res.StatusCode {
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	case http.StatusInternalServerError:
+		return &loaderError{err: err}
+	case http.StatusRequestEntityTooLarge:
+		return fmt.Errorf("%w: %v", ErrValueTooLarge, err)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		//认证失败同时也是节点不可用，请求方回退到本地
+		return &peerError{err: fmt.Errorf("%w: %v", ErrUnauthorized, err)}
+	}
+	return &peerError{err: err}
+}
+
+// 通过 PUT 请求把缓存值写入远程节点
+func (h *httpGetter) Set(in *pb.SetRequest, out *pb.Response) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encoding request body: %v", err)
+	}
+	return h.send(http.MethodPut, h.url(in.GetGroup(), in.GetKey()), body)
+}
+
+// 通过 DELETE 请求删除远程节点上的缓存值
+func (h *httpGetter) Remove(in *pb.Request, out *pb.Response) error {
+	return h.send(http.MethodDelete, h.url(in.GetGroup(), in.GetKey()), nil)
+}
+
+// 发送不需要读取返回内容的请求
+func (h *httpGetter) send(method, u string, body []byte) error {
+	err := h.do(method, u, body)
+	h.breaker.record(err)
+	return err
+}
+
+func (h *httpGetter) do(method, u string, body []byte) error {
+	req, err := h.newRequest(context.Background(), method, u, body)
+	if err != nil {
+		return err
+	}
+	res, err := h.httpClient().Do(req)
+	if err != nil {
+		return &peerError{err: err}
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		return statusError(res)
+	}
+	return nil
+}
+
+// 访问远程节点的健康检查接口
+func (h *httpGetter) health() error {
+	res, err := h.httpClient().Get(h.baseURL + healthPath)
+	if err != nil {
+		return &peerError{err: err}
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return statusError(res)
+	}
+	return nil
+}
+
+// 没有通过 HTTPPool 创建的 httpGetter 使用默认的客户端
+func (h *httpGetter) httpClient() *http.Client {
+	if h.client != nil {
+		return h.client
+	}
+	return defaultClient
+}
+
+var defaultClient = &http.Client{Timeout: defaultPeerTimeout}
+
+var _ ContextPeerGetter = (*httpGetter)(nil)
+var _ BatchPeerGetter = (*httpGetter)(nil)
+
+// 所属节点不可用时，顺时针依次尝试后面的节点
+// 写请求只发给第一个节点，与读请求选中的节点保持一致
+type retryGetter struct {
+	getters []*httpGetter
+}
+
+func (r *retryGetter) Get(in *pb.Request, out *pb.Response) error {
+	return r.GetContext(context.Background(), in, out)
+}
+
+func (r *retryGetter) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	var err error
+	for _, h := range r.getters {
+		err = h.GetContext(ctx, in, out)
+		//只有节点不可用时才换下一个节点，key 不存在等错误是确定的结果
+		if !errors.Is(err, ErrPeerUnavailable) || ctx.Err() != nil {
+			return err
+		}
+		log.Println("[GeeCache] retry on next peer:", err)
+	}
+	return err
+}
+
+func (r *retryGetter) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	var err error
+	for _, h := range r.getters {
+		err = h.GetMany(ctx, in, out)
+		if !errors.Is(err, ErrPeerUnavailable) || ctx.Err() != nil {
+			return err
+		}
+		log.Println("[GeeCache] retry on next peer:", err)
+	}
+	return err
+}
+
+// 按第一个节点分组
+func (r *retryGetter) addr() string {
+	return r.getters[0].addr()
+}
+
+func (r *retryGetter) Set(in *pb.SetRequest, out *pb.Response) error {
+	return r.getters[0].Set(in, out)
+}
+
+func (r *retryGetter) Remove(in *pb.Request, out *pb.Response) error {
+	return r.getters[0].Remove(in, out)
+}
+
+var _ ContextPeerGetter = (*retryGetter)(nil)
+var _ BatchPeerGetter = (*retryGetter)(nil)
@@ -0,0 +1,172 @@
+// LFU 淘汰策略：淘汰访问次数最少的记录，次数相同时淘汰最久未访问的。
+// 访问次数相同的记录放在同一个桶里，桶按照次数从小到大串成链表，
+// 所以 Get/Add/淘汰都是 O(1) 的。
+
+package lfu
+
+import (
+	"container/list"
+	"geecache/policy"
+	"time"
+)
+
+type Cache struct {
+	//允许使用的最大内存
+	maxBytes int64
+	//当前已经使用的内存
+	useBytes int64
+	//频次桶链表，从 front 到 back 访问次数递增
+	freqs *list.List
+	//字典值，key 对应的记录
+	cache map[string]*entry
+	//某条记录被移除时的回调函数
+	OnEvicted policy.EvictFunc
+}
+
+// 访问次数相同的记录组成的桶，桶内 front 是最近访问的记录
+type bucket struct {
+	freq  int
+	items *list.List
+}
+
+type entry struct {
+	key    string
+	value  policy.Value
+	expire time.Time
+	//所在的频次桶
+	bucket *list.Element
+	//在桶内链表中的结点
+	ele *list.Element
+}
+
+func New(maxBytes int64, onEvicted policy.EvictFunc) *Cache {
+	return &Cache{
+		maxBytes:  maxBytes,
+		freqs:     list.New(),
+		cache:     make(map[string]*entry),
+		OnEvicted: onEvicted,
+	}
+}
+
+// 满足 policy.Factory
+func NewPolicy(maxBytes int64, onEvicted policy.EvictFunc) policy.Policy {
+	return New(maxBytes, onEvicted)
+}
+
+var _ policy.Policy = (*Cache)(nil)
+var _ policy.Ranger = (*Cache)(nil)
+
+func (c *Cache) Len() int {
+	return len(c.cache)
+}
+
+func (c *Cache) Bytes() int64 {
+	return c.useBytes
+}
+
+func (c *Cache) Get(key string) (value policy.Value, ok bool) {
+	e, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	if policy.Expired(e.expire, time.Now()) {
+		c.removeEntry(e, policy.EvictExpired)
+		return nil, false
+	}
+	c.increment(e)
+	return e.value, true
+}
+
+func (c *Cache) AddWithExpire(key string, value policy.Value, expire time.Time) {
+	if e, ok := c.cache[key]; ok {
+		c.useBytes += int64(value.Len()) - int64(e.value.Len())
+		e.value = value
+		e.expire = expire
+		c.increment(e)
+	} else {
+		e := &entry{key: key, value: value, expire: expire}
+		//新记录进入访问次数为 1 的桶
+		front := c.freqs.Front()
+		if front == nil || front.Value.(*bucket).freq != 1 {
+			front = c.freqs.PushFront(&bucket{freq: 1, items: list.New()})
+		}
+		e.bucket = front
+		e.ele = front.Value.(*bucket).items.PushFront(e)
+		c.cache[key] = e
+		c.useBytes += int64(len(key)) + int64(value.Len())
+	}
+	for c.maxBytes != 0 && c.maxBytes < c.useBytes {
+		c.removeOldest()
+	}
+}
+
+func (c *Cache) RemoveKey(key string) bool {
+	e, ok := c.cache[key]
+	if ok {
+		c.removeEntry(e, policy.EvictRemoved)
+	}
+	return ok
+}
+
+func (c *Cache) RemoveExpired(now time.Time) int {
+	n := 0
+	for _, e := range c.cache {
+		if policy.Expired(e.expire, now) {
+			c.removeEntry(e, policy.EvictExpired)
+			n++
+		}
+	}
+	return n
+}
+
+// 把记录移动到访问次数加一的桶里
+func (c *Cache) increment(e *entry) {
+	cur := e.bucket
+	b := cur.Value.(*bucket)
+	next := cur.Next()
+	if next == nil || next.Value.(*bucket).freq != b.freq+1 {
+		next = c.freqs.InsertAfter(&bucket{freq: b.freq + 1, items: list.New()}, cur)
+	}
+	b.items.Remove(e.ele)
+	e.bucket = next
+	e.ele = next.Value.(*bucket).items.PushFront(e)
+	if b.items.Len() == 0 {
+		c.freqs.Remove(cur)
+	}
+}
+
+// 淘汰访问次数最少的桶里最久未访问的记录
+func (c *Cache) removeOldest() {
+	front := c.freqs.Front()
+	if front == nil {
+		return
+	}
+	c.removeEntry(front.Value.(*bucket).items.Back().Value.(*entry), policy.EvictCapacity)
+}
+
+func (c *Cache) removeEntry(e *entry, reason policy.EvictReason) {
+	b := e.bucket.Value.(*bucket)
+	b.items.Remove(e.ele)
+	if b.items.Len() == 0 {
+		c.freqs.Remove(e.bucket)
+	}
+	delete(c.cache, e.key)
+	c.useBytes -= int64(len(e.key)) + int64(e.value.Len())
+	if c.OnEvicted != nil {
+		c.OnEvicted(e.key, e.value, reason)
+	}
+}
+
+// 从访问次数最少的桶开始遍历，桶内从最早访问的记录开始
+// 重新添加后访问次数都从 1 开始，只保留了相对顺序
+func (c *Cache) Range(fn func(key string, value policy.Value, expire time.Time) bool) {
+	for b := c.freqs.Front(); b != nil; b = b.Next() {
+		items := b.Value.(*bucket).items
+		for ele := items.Back(); ele != nil; ele = ele.Prev() {
+			e := ele.Value.(*entry)
+			if !fn(e.key, e.value, e.expire) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package lfu
+
+import (
+	"geecache/policy"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (s String) Len() int {
+	return len(s)
+}
+
+func TestGet(t *testing.T) {
+	lfu := New(int64(0), nil)
+	lfu.AddWithExpire("key1", String("1234"), time.Time{})
+	if v, ok := lfu.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+	if _, ok := lfu.Get("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+}
+
+// 访问次数最少的记录先被淘汰，次数相同时淘汰最久未访问的
+func TestRemoveLeastFrequent(t *testing.T) {
+	keys := make([]string, 0)
+	lfu := New(int64(12), func(key string, value policy.Value, reason policy.EvictReason) {
+		keys = append(keys, key)
+	})
+	lfu.AddWithExpire("k1", String("v1"), time.Time{})
+	lfu.AddWithExpire("k2", String("v2"), time.Time{})
+	lfu.AddWithExpire("k3", String("v3"), time.Time{})
+	lfu.Get("k1")
+	lfu.Get("k1")
+	lfu.Get("k3")
+	lfu.AddWithExpire("k4", String("v4"), time.Time{})
+	lfu.AddWithExpire("k5", String("v5"), time.Time{})
+
+	expect := []string{"k2", "k4"}
+	if !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("expect evicted keys %v, got %v", expect, keys)
+	}
+	if _, ok := lfu.Get("k1"); !ok || lfu.Len() != 3 {
+		t.Fatalf("frequent key k1 should stay in cache")
+	}
+}
+
+func TestExpire(t *testing.T) {
+	lfu := New(int64(0), nil)
+	lfu.AddWithExpire("k1", String("v1"), time.Now().Add(-time.Second))
+	lfu.AddWithExpire("k2", String("v2"), time.Now().Add(time.Hour))
+	if _, ok := lfu.Get("k1"); ok {
+		t.Fatalf("expired k1 should miss")
+	}
+	if n := lfu.RemoveExpired(time.Now().Add(2 * time.Hour)); n != 1 || lfu.Len() != 0 {
+		t.Fatalf("RemoveExpired should remove k2, removed %d", n)
+	}
+	if lfu.useBytes != 0 || lfu.freqs.Len() != 0 {
+		t.Fatalf("all buckets should be released")
+	}
+}
@@ -1,97 +1,281 @@
+// 泛型的 LRU 缓存：容量超过上限时淘汰最久没有访问的记录。
+//
+// 双向链表是侵入式的，结点保存在一个切片里，前后指针是切片下标，删除的结点放进空闲链表复用，
+// 所以除了切片和 map 扩容，Add 不会为每条记录单独分配内存，也没有 interface 装箱。
+// 默认不加锁，需要并发访问时使用 WithLock。
+
 package lru
 
 import (
-	"container/list"
+	"geecache/policy"
+	"sync"
 )
 
-type Cache struct {
-	//允许使用的最大内存
-	maxBytes int64
-	//当前已经使用的最大内存
-	useBytes int64
-	//go的标准库实现双向链表
-	ll *list.List
-	//字典值，key时string，值是双向链表中对应结点的指针
-	cache map[string]*list.Element
-	//某条记录被移除时的回调函数
-	OnEvicted func(key string, value Value)
+type Cache[K comparable, V any] struct {
+	//允许使用的最大容量，0 表示不限制
+	maxSize int64
+	//当前已经使用的容量
+	size int64
+	//计算一条记录占用的容量，默认每条记录占 1，maxSize 就是记录个数的上限
+	sizeOf func(key K, value V) int64
+	//nodes[0] 是哨兵，它的 next 是最近访问的结点，prev 是最久没有访问的结点
+	nodes []node[K, V]
+	//空闲结点组成的单链表，通过 next 连接，0 表示没有空闲结点
+	free int32
+	//key 对应的结点在 nodes 中的下标
+	index map[K]int32
+	//某条记录被移除时的回调函数，在持有锁时调用，不能再访问这个 Cache
+	onEvicted func(key K, value V, reason policy.EvictReason)
+	//WithLock 时不为 nil
+	mu *sync.Mutex
+}
+
+type node[K comparable, V any] struct {
+	key   K
+	value V
+	size  int64
+	prev  int32
+	next  int32
+}
+
+// 创建 Cache 时的可选配置
+type Option[K comparable, V any] func(*Cache[K, V])
+
+// 设置计算记录大小的函数，例如按字节数限制内存时返回 key 和 value 的长度之和
+func WithSize[K comparable, V any](sizeOf func(key K, value V) int64) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.sizeOf = sizeOf
+	}
 }
 
-// 键值对 entry 是双向链表节点的数据类型
-type entry struct {
-	key   string
-	value Value
+// 设置记录因为容量不足被淘汰、被 RemoveKey 删除或者被 Purge 清空时的回调函数
+func WithOnEvicted[K comparable, V any](f func(key K, value V)) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.onEvicted = func(key K, value V, _ policy.EvictReason) {
+			f(key, value)
+		}
+	}
+}
+
+// 所有方法都加锁，可以在多个 goroutine 中使用
+func WithLock[K comparable, V any]() Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.mu = new(sync.Mutex)
+	}
+}
+
+// 工厂模式实例化，maxSize 为 0 表示不限制
+func New[K comparable, V any](maxSize int64, opts ...Option[K, V]) *Cache[K, V] {
+	c := &Cache[K, V]{
+		maxSize: maxSize,
+		sizeOf:  func(K, V) int64 { return 1 },
+		nodes:   make([]node[K, V], 1),
+		index:   make(map[K]int32),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
-// 为了通用性，我们允许值是实现了 Value 接口的任意类型
-type Value interface {
-	Len() int
+func (c *Cache[K, V]) lock() {
+	if c.mu != nil {
+		c.mu.Lock()
+	}
 }
 
-// 工厂模式实例化
-func New(max int64, onEvicted func(string, Value)) *Cache {
-	return &Cache{
-		maxBytes:  max,
-		ll:        list.New(),
-		cache:     make(map[string]*list.Element),
-		OnEvicted: onEvicted,
+func (c *Cache[K, V]) unlock() {
+	if c.mu != nil {
+		c.mu.Unlock()
 	}
 }
 
 // 获取添加了多少数据
-func (c *Cache) Len() int {
-	return c.ll.Len()
+func (c *Cache[K, V]) Len() int {
+	c.lock()
+	defer c.unlock()
+	return len(c.index)
+}
+
+// 获取已经使用的容量
+func (c *Cache[K, V]) Size() int64 {
+	c.lock()
+	defer c.unlock()
+	return c.size
+}
+
+// 查找元素，命中时把结点移动到表头
+func (c *Cache[K, V]) Get(key K) (value V, ok bool) {
+	c.lock()
+	defer c.unlock()
+	i, ok := c.index[key]
+	if !ok {
+		return value, false
+	}
+	c.moveToFront(i)
+	return c.nodes[i].value, true
+}
+
+// 查找元素，不改变访问顺序
+func (c *Cache[K, V]) Peek(key K) (value V, ok bool) {
+	c.lock()
+	defer c.unlock()
+	i, ok := c.index[key]
+	if !ok {
+		return value, false
+	}
+	return c.nodes[i].value, true
+}
+
+// 判断 key 是否存在，不改变访问顺序
+func (c *Cache[K, V]) Contains(key K) bool {
+	c.lock()
+	defer c.unlock()
+	_, ok := c.index[key]
+	return ok
+}
+
+// 新增/修改，容量超过上限时淘汰最久没有访问的结点，新增的记录本身超过上限时也会被淘汰
+func (c *Cache[K, V]) Add(key K, value V) {
+	c.lock()
+	defer c.unlock()
+	c.add(key, value)
+}
+
+func (c *Cache[K, V]) add(key K, value V) {
+	size := c.sizeOf(key, value)
+	if i, ok := c.index[key]; ok {
+		n := &c.nodes[i]
+		c.size += size - n.size
+		n.value, n.size = value, size
+		c.moveToFront(i)
+	} else {
+		i := c.alloc()
+		c.nodes[i] = node[K, V]{key: key, value: value, size: size}
+		c.pushFront(i)
+		c.index[key] = i
+		c.size += size
+	}
+	c.evict()
+}
+
+// 删除指定的结点，不存在时返回 false
+func (c *Cache[K, V]) RemoveKey(key K) bool {
+	c.lock()
+	defer c.unlock()
+	return c.remove(key, policy.EvictRemoved)
 }
 
-// 查找元素，从字典中查到对应链表中的结点
-func (c *Cache) Get(key string) (value Value, ok bool) {
-	ele, ok := c.cache[key]
+func (c *Cache[K, V]) remove(key K, reason policy.EvictReason) bool {
+	i, ok := c.index[key]
 	if ok {
-		//将结点ele移动到队尾，约定front是队尾
-		c.ll.MoveToFront(ele)
-		kv := ele.Value.(*entry)
-		//fmt.Println(kv.value)
-		return kv.value, true
-	}
-	return
-}
-
-// 移除元素，就是缓存淘汰，移除最近最少访问的结点（队首）
-func (c *Cache) Remove() {
-	//首先拿到队首结点，是个临时变量
-	ele := c.ll.Back()
-	if ele != nil {
-		//将双向链表中的结点删除
-		c.ll.Remove(ele)
-		kv := ele.Value.(*entry)
-		//从字典中删除该结点的映射关系
-		delete(c.cache, kv.key)
-		//更新当前已经使用的内存
-		c.useBytes -= int64(len(kv.key)) + int64(kv.value.Len())
-		//调用回调函数
-		if c.OnEvicted != nil {
-			c.OnEvicted(kv.key, kv.value)
+		c.removeNode(i, reason)
+	}
+	return ok
+}
+
+// 淘汰最久没有访问的结点，并返回它
+func (c *Cache[K, V]) RemoveOldest() (key K, value V, ok bool) {
+	c.lock()
+	defer c.unlock()
+	i := c.nodes[0].prev
+	if i == 0 {
+		return key, value, false
+	}
+	key, value = c.nodes[i].key, c.nodes[i].value
+	c.removeNode(i, policy.EvictCapacity)
+	return key, value, true
+}
+
+// 从最久没有访问的结点开始返回所有 key，依次 Add 可以恢复原来的顺序
+func (c *Cache[K, V]) Keys() []K {
+	c.lock()
+	defer c.unlock()
+	keys := make([]K, 0, len(c.index))
+	for i := c.nodes[0].prev; i != 0; i = c.nodes[i].prev {
+		keys = append(keys, c.nodes[i].key)
+	}
+	return keys
+}
+
+// 修改容量上限，返回因此被淘汰的记录个数
+func (c *Cache[K, V]) Resize(maxSize int64) int {
+	c.lock()
+	defer c.unlock()
+	c.maxSize = maxSize
+	return c.evict()
+}
+
+// 清空所有记录，每条记录都会调用回调函数
+func (c *Cache[K, V]) Purge() {
+	c.lock()
+	defer c.unlock()
+	if c.onEvicted != nil {
+		for i := c.nodes[0].prev; i != 0; i = c.nodes[i].prev {
+			c.onEvicted(c.nodes[i].key, c.nodes[i].value, policy.EvictRemoved)
 		}
 	}
+	//清零之后 key 和 value 引用的内存才能被回收
+	for i := range c.nodes {
+		c.nodes[i] = node[K, V]{}
+	}
+	c.nodes = c.nodes[:1]
+	c.free = 0
+	c.index = make(map[K]int32)
+	c.size = 0
 }
 
-// 新增/修改
-func (c *Cache) Add(key string, value Value) {
-	//如果此key-value存在，则更新值
-	if ele, ok := c.cache[key]; ok {
-		//链表中对应的结点移动到队尾
-		c.ll.MoveToFront(ele)
-		kv := ele.Value.(*entry)
-		c.useBytes += int64(value.Len()) - int64(kv.value.Len())
-		kv.value = value
-	} else {
-		//不存在此key-value，则新增
-		ele := c.ll.PushFront(&entry{key, value})
-		c.cache[key] = ele
-		c.useBytes += int64(len(key)) + int64(value.Len())
+// 容量超过上限时从表尾淘汰，返回淘汰的个数
+func (c *Cache[K, V]) evict() int {
+	n := 0
+	for c.maxSize != 0 && c.maxSize < c.size {
+		c.removeNode(c.nodes[0].prev, policy.EvictCapacity)
+		n++
+	}
+	return n
+}
+
+// 取一个空闲结点，没有时在切片末尾追加
+func (c *Cache[K, V]) alloc() int32 {
+	if i := c.free; i != 0 {
+		c.free = c.nodes[i].next
+		return i
 	}
-	//如果更新超过了最大值，则移除最少访问的结点
-	for c.maxBytes != 0 && c.maxBytes < c.useBytes {
-		c.Remove()
+	c.nodes = append(c.nodes, node[K, V]{})
+	return int32(len(c.nodes) - 1)
+}
+
+func (c *Cache[K, V]) removeNode(i int32, reason policy.EvictReason) {
+	n := &c.nodes[i]
+	key, value := n.key, n.value
+	c.unlink(i)
+	delete(c.index, key)
+	c.size -= n.size
+	//放回空闲链表
+	*n = node[K, V]{next: c.free}
+	c.free = i
+	if c.onEvicted != nil {
+		c.onEvicted(key, value, reason)
+	}
+}
+
+func (c *Cache[K, V]) pushFront(i int32) {
+	head := &c.nodes[0]
+	c.nodes[i].prev, c.nodes[i].next = 0, head.next
+	c.nodes[head.next].prev = i
+	head.next = i
+}
+
+func (c *Cache[K, V]) unlink(i int32) {
+	n := &c.nodes[i]
+	c.nodes[n.prev].next = n.next
+	c.nodes[n.next].prev = n.prev
+}
+
+func (c *Cache[K, V]) moveToFront(i int32) {
+	if c.nodes[0].next == i {
+		return
 	}
+	c.unlink(i)
+	c.pushFront(i)
 }
@@ -1,9 +1,13 @@
 package lru
 
 import (
+	"container/list"
 	"fmt"
 	"reflect"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 )
 
 type String string
@@ -12,15 +16,21 @@ func (s String) Len() int {
 	return len(s)
 }
 
+// 按 key 和 value 的字节数计算容量，和原来的 Cache 一样
+func newBytes(max int64, opts ...Option[string, string]) *Cache[string, string] {
+	opts = append(opts, WithSize(func(key, value string) int64 {
+		return int64(len(key) + len(value))
+	}))
+	return New(max, opts...)
+}
+
 // 测试Get方法
 func TestGet(t *testing.T) {
-	lru := New(int64(0), nil)
-	lru.Add("key1", String("1234"))
-	v, ok := lru.Get("key1")
-	if !ok || string(v.(String)) != "1234" {
+	lru := newBytes(0)
+	lru.Add("key1", "1234")
+	if v, ok := lru.Get("key1"); !ok || v != "1234" {
 		t.Fatalf("cache hit key1=1234 failed")
 	}
-	fmt.Println(ok)
 	if _, ok := lru.Get("key2"); ok {
 		t.Fatalf("cache miss key2 failed")
 	}
@@ -31,48 +41,325 @@ func TestRemoveoldest(t *testing.T) {
 	k1, k2, k3 := "key1", "key2", "k3"
 	v1, v2, v3 := "value1", "value2", "v3"
 	cap := len(k1 + k2 + v1 + v2)
-	lru := New(int64(cap), nil)
-	lru.Add(k1, String(v1))
-	lru.Add(k2, String(v2))
-	lru.Add(k3, String(v3))
+	lru := newBytes(int64(cap))
+	lru.Add(k1, v1)
+	lru.Add(k2, v2)
+	lru.Add(k3, v3)
 	_, ok := lru.Get("key1")
 	if ok || lru.Len() != 2 {
 		fmt.Println(ok)
 		t.Fatalf("Removeoldest key1 failed")
-	} else {
-		t.Fatalf("Removeoldest key1 succeed")
+	}
+	if key, _, ok := lru.RemoveOldest(); !ok || key != k2 || lru.Len() != 1 {
+		t.Fatalf("RemoveOldest should remove key2, got %s", key)
 	}
 }
 
 // 测试回调函数可否被使用
 func TestOnEvicted(t *testing.T) {
 	keys := make([]string, 0)
-	callback := func(key string, value Value) {
+	callback := func(key string, value string) {
 		keys = append(keys, key)
 	}
-	lru := New(int64(10), callback)
-	lru.Add("key1", String("123456"))
-	lru.Add("k2", String("k2"))
-	lru.Add("k3", String("k3"))
-	lru.Add("k4", String("k4"))
+	lru := newBytes(int64(10), WithOnEvicted(callback))
+	lru.Add("key1", "123456")
+	lru.Add("k2", "k2")
+	lru.Add("k3", "k3")
+	lru.Add("k4", "k4")
 
 	expect := []string{"key1", "k2"}
-	//不清楚这里的DeepEqual函数是干什么的
 	if !reflect.DeepEqual(expect, keys) {
 		t.Fatalf("Call OnEvicted failed, expect keys equals to %s", expect)
 	}
-	fmt.Println(keys)
 }
 
 // 测试是否被正确添加
 func TestAdd(t *testing.T) {
-	lru := New(int64(0), nil)
-	lru.Add("key", String("1"))
-	_, ok := lru.Get("key")
-	fmt.Println(ok)
-	lru.Add("key", String("111"))
+	lru := newBytes(0)
+	lru.Add("key", "1")
+	lru.Add("key", "111")
+
+	if lru.Size() != int64(len("key")+len("111")) {
+		t.Fatal("expected 6 but got", lru.Size())
+	}
+	//默认每条记录占 1，容量就是记录个数
+	count := New[int, int](2)
+	for i := 0; i < 3; i++ {
+		count.Add(i, i)
+	}
+	if count.Len() != 2 || count.Size() != 2 || count.Contains(0) {
+		t.Fatalf("expect the 2 newest entries, got %v", count.Keys())
+	}
+}
+
+// Peek 和 Contains 不改变访问顺序，Keys 从最久没有访问的结点开始
+func TestPeek(t *testing.T) {
+	lru := New[string, int](3)
+	lru.Add("k1", 1)
+	lru.Add("k2", 2)
+	lru.Add("k3", 3)
+	if v, ok := lru.Peek("k1"); !ok || v != 1 || !lru.Contains("k1") {
+		t.Fatalf("k1 should be found")
+	}
+	if _, ok := lru.Peek("k4"); ok || lru.Contains("k4") {
+		t.Fatalf("k4 should not be found")
+	}
+	if keys := lru.Keys(); !reflect.DeepEqual(keys, []string{"k1", "k2", "k3"}) {
+		t.Fatalf("Peek should not touch k1, got %v", keys)
+	}
+	lru.Get("k1")
+	if keys := lru.Keys(); !reflect.DeepEqual(keys, []string{"k2", "k3", "k1"}) {
+		t.Fatalf("Get should move k1 to the front, got %v", keys)
+	}
+	lru.Add("k4", 4)
+	if lru.Contains("k2") {
+		t.Fatalf("k2 should be evicted after Peek and Get")
+	}
+}
+
+func TestResizeAndPurge(t *testing.T) {
+	var evicted []int
+	lru := New(0, WithOnEvicted(func(key int, value string) {
+		evicted = append(evicted, key)
+	}))
+	for i := 0; i < 5; i++ {
+		lru.Add(i, strconv.Itoa(i))
+	}
+	if n := lru.Resize(2); n != 3 || !reflect.DeepEqual(evicted, []int{0, 1, 2}) {
+		t.Fatalf("Resize should evict the 3 oldest entries, got %d %v", n, evicted)
+	}
+	if !lru.RemoveKey(3) || lru.RemoveKey(3) || lru.Len() != 1 {
+		t.Fatalf("RemoveKey 3 failed")
+	}
+
+	evicted = nil
+	lru.Purge()
+	if lru.Len() != 0 || lru.Size() != 0 || len(lru.Keys()) != 0 || !reflect.DeepEqual(evicted, []int{4}) {
+		t.Fatalf("Purge should remove everything, got %v", evicted)
+	}
+	lru.Add(5, "5")
+	if v, ok := lru.Get(5); !ok || v != "5" {
+		t.Fatalf("cache should work after Purge")
+	}
+}
+
+// 删除的结点被复用，反复增删不会让结点切片一直增长
+func TestNodeReuse(t *testing.T) {
+	lru := New[int, int](100)
+	for i := 0; i <= 10000; i++ {
+		lru.Add(i, i)
+		if i%3 == 0 {
+			lru.RemoveKey(i)
+		}
+	}
+	//哨兵、100 条记录，再加上 Add 时先插入再淘汰的一个结点
+	if len(lru.nodes) > 102 {
+		t.Fatalf("expect at most 102 nodes, got %d", len(lru.nodes))
+	}
+	keys := lru.Keys()
+	if len(keys) != 100 || keys[len(keys)-1] != 10000 {
+		t.Fatalf("expect the 100 newest keys, got %d keys ending with %d", len(keys), keys[len(keys)-1])
+	}
+}
 
-	if lru.useBytes != int64(len("key")+len("111")) {
-		t.Fatal("expected 6 but got", lru.useBytes)
+// 使用 go test -race 检查
+func TestLock(t *testing.T) {
+	lru := New(64, WithLock[int, int]())
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := g*1000 + i
+				lru.Add(key, i)
+				lru.Get(key - 1)
+				lru.Peek(key - 2)
+				if i%10 == 0 {
+					lru.RemoveKey(key - 3)
+					lru.Keys()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if lru.Len() > 64 || int64(lru.Len()) != lru.Size() {
+		t.Fatalf("expect at most 64 entries, got %d with size %d", lru.Len(), lru.Size())
+	}
+}
+
+// 测试过期的记录在 Get 时被惰性删除，并且回调中带有淘汰原因
+func TestExpire(t *testing.T) {
+	reasons := make(map[string]EvictReason)
+	lru := NewPolicy(int64(0), func(key string, value Value, reason EvictReason) {
+		reasons[key] = reason
+	})
+	lru.AddWithExpire("key1", String("1234"), time.Now().Add(-time.Second))
+	lru.AddWithExpire("key2", String("5678"), time.Now().Add(time.Hour))
+	lru.AddWithExpire("key3", String("90"), time.Time{})
+
+	if _, ok := lru.Get("key1"); ok {
+		t.Fatalf("expired key1 should miss")
+	}
+	if reasons["key1"] != EvictExpired || lru.Len() != 2 {
+		t.Fatalf("key1 should be evicted as expired, got %v", reasons)
+	}
+	if _, ok := lru.Get("key2"); !ok {
+		t.Fatalf("key2 should not expire yet")
 	}
+
+	// 两小时之后 key2 过期，key3 永不过期
+	if n := lru.RemoveExpired(time.Now().Add(2 * time.Hour)); n != 1 {
+		t.Fatalf("RemoveExpired should remove 1 key, got %d", n)
+	}
+	if _, ok := lru.Get("key3"); !ok || lru.Len() != 1 {
+		t.Fatalf("key3 should never expire")
+	}
+	if lru.Bytes() != int64(len("key3")+len("90")) {
+		t.Fatal("expected 6 but got", lru.Bytes())
+	}
+}
+
+func TestRemoveKey(t *testing.T) {
+	var reason EvictReason
+	lru := NewPolicy(int64(0), func(key string, value Value, r EvictReason) {
+		reason = r
+	})
+	lru.AddWithExpire("key1", String("1234"), time.Time{})
+	if !lru.RemoveKey("key1") || reason != EvictRemoved {
+		t.Fatalf("RemoveKey key1 failed")
+	}
+	if lru.RemoveKey("key1") || lru.Len() != 0 || lru.Bytes() != 0 {
+		t.Fatalf("key1 should be gone")
+	}
+}
+
+// 从最近最少访问的结点开始遍历
+func TestRange(t *testing.T) {
+	lru := NewPolicy(int64(0), nil)
+	lru.AddWithExpire("k1", String("v1"), time.Time{})
+	lru.AddWithExpire("k2", String("v2"), time.Time{})
+	lru.AddWithExpire("k3", String("v3"), time.Time{})
+	lru.Get("k1")
+
+	var keys []string
+	lru.(*policyCache).Range(func(key string, value Value, expire time.Time) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if expect := []string{"k2", "k3", "k1"}; !reflect.DeepEqual(keys, expect) {
+		t.Fatalf("expect %v, got %v", expect, keys)
+	}
+}
+
+// 原来基于 container/list 的实现，只保留 Get 和 Add，作为 benchmark 的基准
+type listCache struct {
+	maxBytes int64
+	useBytes int64
+	ll       *list.List
+	cache    map[string]*list.Element
+}
+
+type listEntry struct {
+	key   string
+	value Value
+}
+
+func newListCache(maxBytes int64) *listCache {
+	return &listCache{maxBytes: maxBytes, ll: list.New(), cache: make(map[string]*list.Element)}
+}
+
+func (c *listCache) Get(key string) (Value, bool) {
+	if ele, ok := c.cache[key]; ok {
+		c.ll.MoveToFront(ele)
+		return ele.Value.(*listEntry).value, true
+	}
+	return nil, false
+}
+
+func (c *listCache) Add(key string, value Value) {
+	if ele, ok := c.cache[key]; ok {
+		c.ll.MoveToFront(ele)
+		kv := ele.Value.(*listEntry)
+		c.useBytes += int64(value.Len()) - int64(kv.value.Len())
+		kv.value = value
+	} else {
+		c.cache[key] = c.ll.PushFront(&listEntry{key, value})
+		c.useBytes += int64(len(key)) + int64(value.Len())
+	}
+	for c.maxBytes != 0 && c.maxBytes < c.useBytes {
+		ele := c.ll.Back()
+		kv := ele.Value.(*listEntry)
+		c.ll.Remove(ele)
+		delete(c.cache, kv.key)
+		c.useBytes -= int64(len(kv.key)) + int64(kv.value.Len())
+	}
+}
+
+const benchKeys = 1 << 16
+
+func benchKeyNames() []string {
+	keys := make([]string, benchKeys)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+	return keys
+}
+
+// 容量只能放下一半的 key，Add 时持续淘汰
+func BenchmarkAdd(b *testing.B) {
+	keys := benchKeyNames()
+	max := int64(benchKeys / 2 * len("key00000v"))
+	b.Run("list", func(b *testing.B) {
+		c := newListCache(max)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c.Add(keys[i%benchKeys], String("v"))
+		}
+	})
+	b.Run("generic", func(b *testing.B) {
+		c := New(max, WithSize(func(key string, value String) int64 {
+			return int64(len(key) + len(value))
+		}))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c.Add(keys[i%benchKeys], "v")
+		}
+	})
+	b.Run("generic-locked", func(b *testing.B) {
+		c := New(max, WithSize(func(key string, value String) int64 {
+			return int64(len(key) + len(value))
+		}), WithLock[string, String]())
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c.Add(keys[i%benchKeys], "v")
+		}
+	})
+}
+
+// 所有 key 都在缓存中
+func BenchmarkGet(b *testing.B) {
+	keys := benchKeyNames()
+	b.Run("list", func(b *testing.B) {
+		c := newListCache(0)
+		for _, key := range keys {
+			c.Add(key, String("v"))
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			c.Get(keys[i%benchKeys])
+		}
+	})
+	b.Run("generic", func(b *testing.B) {
+		c := New[string, String](0)
+		for _, key := range keys {
+			c.Add(key, "v")
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			c.Get(keys[i%benchKeys])
+		}
+	})
 }
@@ -0,0 +1,104 @@
+package lru
+
+import (
+	"geecache/policy"
+	"time"
+)
+
+// 记录被移除的原因，和其他淘汰策略共用
+type EvictReason = policy.EvictReason
+
+const (
+	//内存超过上限，淘汰最近最少访问的结点
+	EvictCapacity = policy.EvictCapacity
+	//记录已经过期
+	EvictExpired = policy.EvictExpired
+	//调用方主动删除
+	EvictRemoved = policy.EvictRemoved
+)
+
+// 为了通用性，我们允许值是实现了 Value 接口的任意类型
+type Value = policy.Value
+
+// mainCache 中保存的记录
+type item struct {
+	value Value
+	//过期时间，零值表示永不过期
+	expire time.Time
+}
+
+// 用 Cache 实现的 policy.Policy，按 key 和 value 的字节数限制内存
+// 由 mainCache 加锁，Cache 本身不需要加锁
+type policyCache struct {
+	c *Cache[string, item]
+}
+
+// 作为 mainCache 的默认淘汰策略
+func NewPolicy(maxBytes int64, onEvicted policy.EvictFunc) policy.Policy {
+	c := New(maxBytes, WithSize(func(key string, it item) int64 {
+		return int64(len(key)) + int64(it.value.Len())
+	}))
+	if onEvicted != nil {
+		c.onEvicted = func(key string, it item, reason EvictReason) {
+			onEvicted(key, it.value, reason)
+		}
+	}
+	return &policyCache{c: c}
+}
+
+var _ policy.Policy = (*policyCache)(nil)
+var _ policy.Ranger = (*policyCache)(nil)
+
+func (p *policyCache) Len() int {
+	return len(p.c.index)
+}
+
+func (p *policyCache) Bytes() int64 {
+	return p.c.size
+}
+
+// 已经过期的结点在这里被惰性删除，并当作未命中处理
+func (p *policyCache) Get(key string) (value Value, ok bool) {
+	i, ok := p.c.index[key]
+	if !ok {
+		return nil, false
+	}
+	if policy.Expired(p.c.nodes[i].value.expire, time.Now()) {
+		p.c.removeNode(i, EvictExpired)
+		return nil, false
+	}
+	p.c.moveToFront(i)
+	return p.c.nodes[i].value.value, true
+}
+
+func (p *policyCache) AddWithExpire(key string, value Value, expire time.Time) {
+	p.c.add(key, item{value: value, expire: expire})
+}
+
+func (p *policyCache) RemoveKey(key string) bool {
+	return p.c.remove(key, EvictRemoved)
+}
+
+// 清理所有在 now 时刻已经过期的结点，返回清理的个数
+func (p *policyCache) RemoveExpired(now time.Time) int {
+	n := 0
+	for i := p.c.nodes[0].prev; i != 0; {
+		prev := p.c.nodes[i].prev
+		if policy.Expired(p.c.nodes[i].value.expire, now) {
+			p.c.removeNode(i, EvictExpired)
+			n++
+		}
+		i = prev
+	}
+	return n
+}
+
+// 从最近最少访问的结点开始遍历，依次 Add 可以恢复原来的顺序
+func (p *policyCache) Range(fn func(key string, value Value, expire time.Time) bool) {
+	for i := p.c.nodes[0].prev; i != 0; i = p.c.nodes[i].prev {
+		n := &p.c.nodes[i]
+		if !fn(n.key, n.value.value, n.value.expire) {
+			return
+		}
+	}
+}
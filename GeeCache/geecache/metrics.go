@@ -0,0 +1,74 @@
+// 以 Prometheus 文本格式输出所有 Group 的统计信息
+
+package geecache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// 一项指标的描述
+type metric struct {
+	name  string
+	help  string
+	kind  string
+	value func(s Stats) int64
+}
+
+var groupMetrics = []metric{
+	{"geecache_gets_total", "Total number of Get requests.", "counter", func(s Stats) int64 { return s.Gets }},
+	{"geecache_hits_total", "Get requests served from mainCache or hotCache.", "counter", func(s Stats) int64 { return s.Hits }},
+	{"geecache_negative_hits_total", "Get requests answered by the negative cache.", "counter", func(s Stats) int64 { return s.NegativeHits }},
+	{"geecache_disk_hits_total", "Get requests served from the disk tier.", "counter", func(s Stats) int64 { return s.DiskHits }},
+	{"geecache_misses_total", "Get requests that missed the cache.", "counter", func(s Stats) int64 { return s.Misses }},
+	{"geecache_peer_loads_total", "Values loaded from remote peers.", "counter", func(s Stats) int64 { return s.PeerLoads }},
+	{"geecache_peer_errors_total", "Failed loads from remote peers.", "counter", func(s Stats) int64 { return s.PeerErrors }},
+	{"geecache_replica_loads_total", "Values loaded from a replica because the primary owner was unavailable.", "counter", func(s Stats) int64 { return s.ReplicaLoads }},
+	{"geecache_local_loads_total", "Values loaded from the Getter.", "counter", func(s Stats) int64 { return s.LocalLoads }},
+	{"geecache_local_load_errors_total", "Failed loads from the Getter.", "counter", func(s Stats) int64 { return s.LocalLoadErrs }},
+	{"geecache_dedups_total", "Loads merged by singleflight.", "counter", func(s Stats) int64 { return s.Dedups }},
+	{"geecache_server_requests_total", "Requests received from remote peers.", "counter", func(s Stats) int64 { return s.ServerRequests }},
+}
+
+// mainCache 和 hotCache 各自的指标
+type cacheMetric struct {
+	name  string
+	help  string
+	kind  string
+	value func(s CacheStats) int64
+}
+
+var cacheMetrics = []cacheMetric{
+	{"geecache_cache_gets_total", "Lookups in the cache.", "counter", func(s CacheStats) int64 { return s.Gets }},
+	{"geecache_cache_hits_total", "Lookups that hit the cache.", "counter", func(s CacheStats) int64 { return s.Hits }},
+	{"geecache_cache_evictions_total", "Entries evicted by the policy or expired.", "counter", func(s CacheStats) int64 { return s.Evictions }},
+	{"geecache_cache_bytes", "Bytes used by the cache.", "gauge", func(s CacheStats) int64 { return s.Bytes }},
+	{"geecache_cache_items", "Entries in the cache.", "gauge", func(s CacheStats) int64 { return s.Items }},
+}
+
+// 把 Group 的统计信息写成 Prometheus 文本格式
+func writeMetrics(w io.Writer, list []*Group) {
+	stats := make([]Stats, len(list))
+	for i, g := range list {
+		stats[i] = g.Stats()
+	}
+	for _, m := range groupMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for i, g := range list {
+			fmt.Fprintf(w, "%s{group=%q} %d\n", m.name, g.name, m.value(stats[i]))
+		}
+	}
+	for _, m := range cacheMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, g := range list {
+			fmt.Fprintf(w, "%s{group=%q,cache=\"main\"} %d\n", m.name, g.name, m.value(g.CacheStats(MainCache)))
+			fmt.Fprintf(w, "%s{group=%q,cache=\"hot\"} %d\n", m.name, g.name, m.value(g.CacheStats(HotCache)))
+		}
+	}
+}
+
+func serveMetrics(w http.ResponseWriter, r *http.Request, c *Cache) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(w, c.sortedGroups())
+}
@@ -1,6 +1,9 @@
 package geecache
 
-import pb "geecache/geecachepb"
+import (
+	"context"
+	pb "geecache/geecachepb"
+)
 
 // 他的方法用于根据传入的key选择相应的http客户端(PeerGetter)
 type PeerPicker interface {
@@ -8,7 +11,35 @@ type PeerPicker interface {
 }
 
 // 抽象出来的http客户端,他的Get方法用于从对应的group中查找缓存值
+// Set 和 Remove 用于修改远程节点上的缓存值
 type PeerGetter interface {
 	//Get(group string, key string) ([]byte, error)
 	Get(in *pb.Request, out *pb.Response) error
+	Set(in *pb.SetRequest, out *pb.Response) error
+	Remove(in *pb.Request, out *pb.Response) error
+}
+
+// 能够响应 ctx 取消和超时的 PeerGetter
+type ContextPeerGetter interface {
+	PeerGetter
+	GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error
+}
+
+// 支持批量获取的 PeerGetter，Group.GetMany 对每个节点只发送一次请求
+type BatchPeerGetter interface {
+	PeerGetter
+	GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error
+}
+
+// 能够列出所有远程节点的 PeerPicker 可以实现这个接口，用于广播失效消息
+type PeerLister interface {
+	AllPeers() []PeerGetter
+}
+
+// 能够为 key 选择多个所属节点的 PeerPicker，WithReplicas 需要节点池实现这个接口
+type ReplicaPicker interface {
+	PeerPicker
+	//按顺序返回 key 的 n 个所属节点，第一个是主节点，本节点用 nil 表示
+	//已经熔断的远程节点被跳过，由后面的节点补上
+	PickReplicas(key string, n int) []PeerGetter
 }
@@ -0,0 +1,80 @@
+// 淘汰策略的公共接口
+// mainCache 只依赖这里定义的 Policy，具体的 LRU、LFU、ARC、2Q、W-TinyLFU
+// 分别在各自的包中实现，创建 Group 时按需选择。
+
+package policy
+
+import "time"
+
+// 为了通用性，我们允许值是实现了 Value 接口的任意类型
+type Value interface {
+	Len() int
+}
+
+// 记录被移除的原因
+type EvictReason int
+
+const (
+	//内存超过上限，按照策略淘汰
+	EvictCapacity EvictReason = iota
+	//记录已经过期
+	EvictExpired
+	//新记录没有通过准入检查，直接被丢弃
+	EvictRejected
+	//调用方主动删除
+	EvictRemoved
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictExpired:
+		return "expired"
+	case EvictRejected:
+		return "rejected"
+	case EvictRemoved:
+		return "removed"
+	}
+	return "unknown"
+}
+
+// 某条记录被移除时的回调函数
+type EvictFunc func(key string, value Value, reason EvictReason)
+
+// 淘汰策略需要实现的方法，实现不需要是并发安全的，由调用方加锁
+type Policy interface {
+	//新增/修改，expire 为零值表示永不过期
+	AddWithExpire(key string, value Value, expire time.Time)
+	//查找元素，过期的记录当作未命中
+	Get(key string) (value Value, ok bool)
+	//删除指定的记录，记录不存在时返回 false
+	RemoveKey(key string) bool
+	//清理所有在 now 时刻已经过期的记录，返回清理的个数
+	RemoveExpired(now time.Time) int
+	//记录的个数
+	Len() int
+	//记录占用的内存
+	Bytes() int64
+}
+
+// 能够遍历所有记录的淘汰策略，用于把缓存保存到快照
+// 从最先被淘汰的记录开始遍历，按同样的顺序重新添加可以恢复淘汰顺序，fn 返回 false 时停止
+type Ranger interface {
+	Range(fn func(key string, value Value, expire time.Time) bool)
+}
+
+// 能够原地调整内存上限的淘汰策略，超出新上限的记录按照策略淘汰
+// 不实现这个接口的策略在调整大小时按 Range 的顺序把记录重新添加到新的实例中，
+// 带准入检查的策略重新添加时会丢失访问频次，需要原地调整
+type Resizer interface {
+	Resize(maxBytes int64)
+}
+
+// 工厂函数，maxBytes 为 0 表示不限制内存
+type Factory func(maxBytes int64, onEvicted EvictFunc) Policy
+
+// 判断过期时间 expire 在 now 时刻是否已经过期，零值表示永不过期
+func Expired(expire, now time.Time) bool {
+	return !expire.IsZero() && !now.Before(expire)
+}
@@ -0,0 +1,75 @@
+package geecache
+
+import "context"
+
+// 服务发现，用来代替在 main.go 中写死的节点地址
+// 具体实现见 geecache/registry
+type Registry interface {
+	//把自己注册到注册中心，并在后台保持心跳，ctx 取消时注销
+	Register(ctx context.Context, addr string) error
+	//监听节点集合，每次变化时发送完整的节点列表，ctx 取消时关闭 channel
+	Watch(ctx context.Context) (<-chan []string, error)
+}
+
+// 把自己注册到 r，并根据 r 中的节点列表更新哈希环，直到 ctx 取消
+// 心跳超时的节点会从注册中心消失，随后从哈希环中删除
+func (p *HTTPPool) Discover(ctx context.Context, r Registry) error {
+	if err := r.Register(ctx, p.self); err != nil {
+		return err
+	}
+	updates, err := r.Watch(ctx)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for peers := range updates {
+			p.syncPeers(peers)
+		}
+	}()
+	return nil
+}
+
+// 只增删有变化的节点，其余节点负责的 key 保持不变
+func (p *HTTPPool) syncPeers(peers []string) {
+	want := make(map[string]bool, len(peers))
+	for _, peer := range peers {
+		want[peer] = true
+	}
+	var added, removed []string
+	for _, peer := range p.Peers() {
+		if !want[peer] {
+			removed = append(removed, peer)
+		}
+		delete(want, peer)
+	}
+	for peer := range want {
+		added = append(added, peer)
+	}
+	if len(added) > 0 {
+		p.Log("peers joined: %v", added)
+		p.AddPeers(added...)
+	}
+	if len(removed) > 0 {
+		p.Log("peers left: %v", removed)
+		p.RemovePeers(removed...)
+	}
+}
+
+// 和 HTTPPool.Discover 相同，节点列表变化时调用 SetPeers，没有变化的节点保留原来的连接
+func (p *GRPCPool) Discover(ctx context.Context, r Registry) error {
+	if err := r.Register(ctx, p.self); err != nil {
+		return err
+	}
+	updates, err := r.Watch(ctx)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for peers := range updates {
+			if err := p.SetPeers(peers...); err != nil {
+				p.Log("failed to update peers: %v", err)
+			}
+		}
+	}()
+	return nil
+}
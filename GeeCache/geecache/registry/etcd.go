@@ -0,0 +1,236 @@
+// 基于 etcd v3 的服务发现，通过 etcd 自带的 JSON 网关（/v3/...）访问，不依赖 etcd 客户端库。
+// 每个节点以 <prefix><addr> 为 key 写入 etcd，并绑定一个租约，
+// 节点定期续约作为心跳；节点宕机后租约过期，key 被 etcd 删除，其他节点随后把它移出哈希环。
+
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+)
+
+const (
+	defaultEtcdPrefix = "/geecache/nodes/"
+	defaultLeaseTTL   = 10 * time.Second
+	defaultPollPeriod = 2 * time.Second
+)
+
+type Etcd struct {
+	//etcd 的地址，例如 http://127.0.0.1:2379
+	endpoint string
+	prefix   string
+	//租约的有效期，每 ttl/3 续约一次
+	ttl time.Duration
+	//轮询节点列表的间隔
+	interval time.Duration
+	client   *http.Client
+}
+
+func NewEtcd(endpoint string) *Etcd {
+	return &Etcd{
+		endpoint: endpoint,
+		prefix:   defaultEtcdPrefix,
+		ttl:      defaultLeaseTTL,
+		interval: defaultPollPeriod,
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// 设置 key 的前缀，不同的集群使用不同的前缀
+func (e *Etcd) SetPrefix(prefix string) {
+	e.prefix = prefix
+}
+
+// 设置租约的有效期，etcd 的租约以秒为单位
+func (e *Etcd) SetTTL(ttl time.Duration) {
+	e.ttl = ttl
+}
+
+// 设置轮询节点列表的间隔
+func (e *Etcd) SetInterval(d time.Duration) {
+	e.interval = d
+}
+
+// etcd JSON 网关使用的消息，int64 编码为字符串，[]byte 编码为 base64
+type leaseGrantRequest struct {
+	TTL int64 `json:"TTL,string"`
+}
+
+type leaseResponse struct {
+	ID  int64 `json:"ID,string"`
+	TTL int64 `json:"TTL,string"`
+}
+
+type leaseRequest struct {
+	ID int64 `json:"ID,string"`
+}
+
+type keepAliveResponse struct {
+	Result leaseResponse `json:"result"`
+}
+
+type putRequest struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+	Lease int64  `json:"lease,string"`
+}
+
+type rangeRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end"`
+}
+
+type rangeResponse struct {
+	Kvs []struct {
+		Key   []byte `json:"key"`
+		Value []byte `json:"value"`
+	} `json:"kvs"`
+}
+
+// 调用 etcd 的 JSON 接口
+func (e *Etcd) call(ctx context.Context, path string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd %s returned: %v", path, res.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// 申请租约并写入自己的地址
+func (e *Etcd) register(ctx context.Context, addr string) (int64, error) {
+	lease := &leaseResponse{}
+	ttl := int64(e.ttl / time.Second)
+	if ttl < 1 {
+		ttl = 1
+	}
+	if err := e.call(ctx, "/v3/lease/grant", &leaseGrantRequest{TTL: ttl}, lease); err != nil {
+		return 0, err
+	}
+	put := &putRequest{Key: []byte(e.prefix + addr), Value: []byte(addr), Lease: lease.ID}
+	if err := e.call(ctx, "/v3/kv/put", put, nil); err != nil {
+		return 0, err
+	}
+	return lease.ID, nil
+}
+
+func (e *Etcd) Register(ctx context.Context, addr string) error {
+	id, err := e.register(ctx, addr)
+	if err != nil {
+		return err
+	}
+	go e.heartbeat(ctx, addr, id)
+	return nil
+}
+
+// 定期续约，租约已经过期（例如网络中断太久）时重新注册
+// ctx 取消时撤销租约，其他节点可以立即感知到
+func (e *Etcd) heartbeat(ctx context.Context, addr string, id int64) {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			revokeCtx, cancel := context.WithTimeout(context.Background(), e.client.Timeout)
+			e.call(revokeCtx, "/v3/lease/revoke", &leaseRequest{ID: id}, nil)
+			cancel()
+			return
+		case <-ticker.C:
+		}
+		res := &keepAliveResponse{}
+		err := e.call(ctx, "/v3/lease/keepalive", &leaseRequest{ID: id}, res)
+		if err == nil && res.Result.TTL > 0 {
+			continue
+		}
+		if ctx.Err() != nil {
+			continue
+		}
+		log.Printf("[Registry] lease of %s lost, register again: %v", addr, err)
+		if newID, err := e.register(ctx, addr); err == nil {
+			id = newID
+		}
+	}
+}
+
+// 读取前缀下的所有节点
+func (e *Etcd) list(ctx context.Context) ([]string, error) {
+	res := &rangeResponse{}
+	req := &rangeRequest{Key: []byte(e.prefix), RangeEnd: prefixEnd(e.prefix)}
+	if err := e.call(ctx, "/v3/kv/range", req, res); err != nil {
+		return nil, err
+	}
+	peers := make([]string, 0, len(res.Kvs))
+	for _, kv := range res.Kvs {
+		peers = append(peers, string(kv.Value))
+	}
+	sort.Strings(peers)
+	return peers, nil
+}
+
+func (e *Etcd) Watch(ctx context.Context) (<-chan []string, error) {
+	peers, err := e.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []string, 1)
+	ch <- peers
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			//etcd 暂时不可用时保留上一次的节点列表
+			latest, err := e.list(ctx)
+			if err != nil || reflect.DeepEqual(latest, peers) {
+				continue
+			}
+			peers = latest
+			select {
+			case ch <- peers:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// 前缀查询的 range_end：把前缀的最后一个字节加一
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	//前缀全是 0xff，查询到最后
+	return []byte{0}
+}
@@ -0,0 +1,90 @@
+// 基于静态文件的服务发现
+// 文件中每行一个节点地址，空行和 # 开头的行会被忽略。
+// 修改文件之后，各个节点会在下一次轮询时更新哈希环，不需要重启进程。
+
+package registry
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultFileInterval = 5 * time.Second
+
+type File struct {
+	path string
+	//检查文件是否修改的间隔
+	interval time.Duration
+}
+
+func NewFile(path string) *File {
+	return &File{path: path, interval: defaultFileInterval}
+}
+
+// 设置轮询间隔
+func (f *File) SetInterval(d time.Duration) {
+	f.interval = d
+}
+
+// 文件由运维人员维护，注册自己不需要做任何事情
+func (f *File) Register(ctx context.Context, addr string) error {
+	return nil
+}
+
+func (f *File) Watch(ctx context.Context) (<-chan []string, error) {
+	peers, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []string, 1)
+	ch <- peers
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			//读取失败时保留上一次的节点列表，文件可能正在被替换
+			latest, err := f.read()
+			if err != nil || reflect.DeepEqual(latest, peers) {
+				continue
+			}
+			peers = latest
+			select {
+			case ch <- peers:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// 读取并排序文件中的节点地址
+func (f *File) read() ([]string, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	peers := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		peers = append(peers, line)
+	}
+	sort.Strings(peers)
+	return peers, scanner.Err()
+}
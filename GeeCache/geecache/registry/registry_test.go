@@ -0,0 +1,231 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 内存中的 etcd，只实现 JSON 网关中用到的几个接口，租约到期后删除绑定的 key
+type fakeEtcd struct {
+	mu     sync.Mutex
+	nextID int64
+	leases map[int64]time.Time
+	ttls   map[int64]time.Duration
+	kvs    map[string]kvEntry
+}
+
+type kvEntry struct {
+	value []byte
+	lease int64
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{
+		leases: make(map[int64]time.Time),
+		ttls:   make(map[int64]time.Duration),
+		kvs:    make(map[string]kvEntry),
+	}
+}
+
+// 测试中租约的 1 秒按 300ms 计算，避免等待太久
+const leaseUnit = 300 * time.Millisecond
+
+func (f *fakeEtcd) expire() {
+	now := time.Now()
+	for id, deadline := range f.leases {
+		if now.After(deadline) {
+			f.revoke(id)
+		}
+	}
+}
+
+func (f *fakeEtcd) revoke(id int64) {
+	delete(f.leases, id)
+	delete(f.ttls, id)
+	for k, kv := range f.kvs {
+		if kv.lease == id {
+			delete(f.kvs, k)
+		}
+	}
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expire()
+	var out interface{}
+	switch r.URL.Path {
+	case "/v3/lease/grant":
+		var req leaseGrantRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		f.nextID++
+		ttl := time.Duration(req.TTL) * leaseUnit
+		f.leases[f.nextID] = time.Now().Add(ttl)
+		f.ttls[f.nextID] = ttl
+		out = &leaseResponse{ID: f.nextID, TTL: req.TTL}
+	case "/v3/lease/keepalive":
+		var req leaseRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		res := &keepAliveResponse{Result: leaseResponse{ID: req.ID}}
+		if ttl, ok := f.ttls[req.ID]; ok {
+			f.leases[req.ID] = time.Now().Add(ttl)
+			res.Result.TTL = int64(ttl / leaseUnit)
+		}
+		out = res
+	case "/v3/lease/revoke":
+		var req leaseRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		f.revoke(req.ID)
+		out = struct{}{}
+	case "/v3/kv/put":
+		var req putRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if _, ok := f.leases[req.Lease]; req.Lease != 0 && !ok {
+			http.Error(w, "lease not found", http.StatusBadRequest)
+			return
+		}
+		f.kvs[string(req.Key)] = kvEntry{value: req.Value, lease: req.Lease}
+		out = struct{}{}
+	case "/v3/kv/range":
+		var req rangeRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		res := &rangeResponse{}
+		for k, kv := range f.kvs {
+			if bytes.Compare([]byte(k), req.Key) >= 0 && bytes.Compare([]byte(k), req.RangeEnd) < 0 {
+				res.Kvs = append(res.Kvs, struct {
+					Key   []byte `json:"key"`
+					Value []byte `json:"value"`
+				}{[]byte(k), kv.value})
+			}
+		}
+		out = res
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+// 申请 1 秒的租约（即 leaseUnit），每 leaseUnit/3 续约一次
+func newTestEtcd(endpoint string) *Etcd {
+	e := NewEtcd(endpoint)
+	e.SetTTL(leaseUnit)
+	e.SetInterval(20 * time.Millisecond)
+	return e
+}
+
+// 等待 channel 中出现期望的节点列表
+func waitFor(t *testing.T, ch <-chan []string, want []string) {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case peers, ok := <-ch:
+			if !ok {
+				t.Fatalf("watch closed before seeing %v", want)
+			}
+			if reflect.DeepEqual(peers, want) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timeout waiting for peers %v", want)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers")
+	os.WriteFile(path, []byte("# cluster\nhttp://b\n\nhttp://a\n"), 0644)
+
+	f := NewFile(path)
+	f.SetInterval(10 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := f.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, ch, []string{"http://a", "http://b"})
+
+	os.WriteFile(path, []byte("http://a\nhttp://c\n"), 0644)
+	waitFor(t, ch, []string{"http://a", "http://c"})
+
+	cancel()
+	for range ch {
+	}
+	if _, err := NewFile(filepath.Join(t.TempDir(), "missing")).Watch(context.Background()); err == nil {
+		t.Fatalf("watch a missing file should fail")
+	}
+}
+
+func TestEtcd(t *testing.T) {
+	server := httptest.NewServer(newFakeEtcd())
+	defer server.Close()
+
+	watcher := newTestEtcd(server.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := watcher.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, ch, []string{})
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	defer cancelA()
+	if err := newTestEtcd(server.URL).Register(ctxA, "http://a"); err != nil {
+		t.Fatal(err)
+	}
+	ctxB, cancelB := context.WithCancel(context.Background())
+	if err := newTestEtcd(server.URL).Register(ctxB, "http://b"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, ch, []string{"http://a", "http://b"})
+
+	//心跳使租约在多个 TTL 之后仍然有效
+	time.Sleep(3 * leaseUnit)
+	if peers, _ := watcher.list(ctx); !reflect.DeepEqual(peers, []string{"http://a", "http://b"}) {
+		t.Fatalf("heartbeat should keep peers alive, got %v", peers)
+	}
+
+	//正常退出时撤销租约
+	cancelB()
+	waitFor(t, ch, []string{"http://a"})
+}
+
+func TestEtcdLeaseExpire(t *testing.T) {
+	server := httptest.NewServer(newFakeEtcd())
+	defer server.Close()
+
+	e := newTestEtcd(server.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := e.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//只申请租约不续约，模拟节点宕机
+	if _, err := e.register(ctx, "http://dead"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, ch, []string{"http://dead"})
+	waitFor(t, ch, []string{})
+}
+
+func TestPrefixEnd(t *testing.T) {
+	if got := string(prefixEnd("/geecache/nodes/")); got != "/geecache/nodes0" {
+		t.Fatalf("prefixEnd = %q", got)
+	}
+	if got := prefixEnd("\xff"); !bytes.Equal(got, []byte{0}) {
+		t.Fatalf("prefixEnd = %q", got)
+	}
+}
@@ -0,0 +1,120 @@
+// WithReplicas 启用之后，每个 key 保存在从它开始顺时针的 n 个节点上。
+//
+// 读取时按顺序访问这些节点，主节点不可用时从下一个副本读取，只有所有副本都不可用才回源。
+// 回源的节点异步把值写入其他副本；Set 和 Remove 同步作用于所有副本。
+// GetMany 仍然只访问主节点。
+
+package geecache
+
+import (
+	"context"
+	"errors"
+	pb "geecache/geecachepb"
+	"log"
+	"math/rand"
+)
+
+// 依次访问 key 的所属节点，轮到本节点或者所有远程节点都失败时回源
+func (g *Group) loadReplicated(ctx context.Context, rp ReplicaPicker, key string) (interface{}, error) {
+	owners := rp.PickReplicas(key, g.replicas)
+	for i, peer := range owners {
+		//本节点是副本，排在前面的节点都没有这个值，由本节点回源
+		if peer == nil {
+			break
+		}
+		value, err := g.getFromPeer(ctx, peer, key)
+		if err == nil {
+			g.stats.peerLoads.Add(1)
+			if i > 0 {
+				g.stats.replicaLoads.Add(1)
+			}
+			if g.hotRatio > 0 && rand.Float64() < g.hotSampleRate {
+				g.hotCache.add(key, value, value.e)
+			}
+			return value, nil
+		}
+		if errors.Is(err, ErrNotFound) {
+			g.rememberNotFound(key)
+			return nil, err
+		}
+		g.stats.peerErrors.Add(1)
+		log.Println("[GeeCache] Failed to get from replica", err)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	value, err := g.getLocally(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	//本节点是所属节点之一时负责把值写入其他副本
+	for _, peer := range owners {
+		if peer == nil {
+			go g.replicate(owners, key, value)
+			break
+		}
+	}
+	return value, nil
+}
+
+// 把本节点回源得到的值写入其他副本，失败只记录日志，副本会在下次读取时回源
+func (g *Group) replicate(owners []PeerGetter, key string, value ByteView) {
+	req := g.setRequest(key, value)
+	for _, peer := range owners {
+		if peer == nil {
+			continue
+		}
+		if err := peer.Set(req, &pb.Response{}); err != nil {
+			log.Printf("[GeeCache] failed to replicate %s: %v", key, err)
+		}
+	}
+}
+
+// 把值写入 key 的所有所属节点，返回第一个错误
+func (g *Group) setReplicas(rp ReplicaPicker, key string, value ByteView) error {
+	g.hotCache.remove(key)
+	req := g.setRequest(key, value)
+	var firstErr error
+	for _, peer := range rp.PickReplicas(key, g.replicas) {
+		var err error
+		if peer == nil {
+			err = g.populateCache(key, value, value.e)
+		} else {
+			err = peer.Set(req, &pb.Response{})
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// 删除 key 的所有远程所属节点上的值，本地已经由 Remove 删除
+func (g *Group) removeReplicas(rp ReplicaPicker, key string) error {
+	var firstErr error
+	for _, peer := range rp.PickReplicas(key, g.replicas) {
+		if peer == nil {
+			continue
+		}
+		err := peer.Remove(&pb.Request{Group: g.name, Key: key}, &pb.Response{})
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (g *Group) setRequest(key string, value ByteView) *pb.SetRequest {
+	req := &pb.SetRequest{
+		Group: g.name,
+		Key:   key,
+		Value: value.b,
+	}
+	if !value.e.IsZero() {
+		req.Expire = value.e.UnixNano()
+	}
+	return req
+}
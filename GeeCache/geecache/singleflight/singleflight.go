@@ -1,51 +1,193 @@
 package singleflight
 
-import "sync"
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
 
 // 代表正在进行中或者已经结束的请求
 type call struct {
-	wg  sync.WaitGroup
-	val interface{}
-	err error
+	//请求结束时关闭
+	done chan struct{}
+	val  interface{}
+	err  error
+	//f panic 时保存 panic 的值，每个等待的调用者都会重新 panic
+	panicked *panicError
+}
+
+// f panic 时的值和发生 panic 的 goroutine 的调用栈
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (p *panicError) Error() string {
+	return fmt.Sprintf("%v\n\n%s", p.value, p.stack)
+}
+
+// 返回请求的结果，f panic 过的话在当前 goroutine 重新 panic
+func (c *call) result() (interface{}, error) {
+	if c.panicked != nil {
+		panic(c.panicked)
+	}
+	return c.val, c.err
 }
 
 // 管理不同key的请求call
 type Group struct {
 	mutex sync.Mutex
 	m     map[string]*call
+	//加入已有请求、没有再调用 f 的次数
+	dups atomic.Int64
+}
+
+// 返回加入已有请求的调用次数，也就是被合并掉的调用次数
+func (g *Group) Dups() int64 {
+	return g.dups.Load()
 }
 
 // 第一个参数是key，第二个参数是函数调用。
 // Do 的作用就是，针对相同的 key，
 // 无论 Do 被调用多少次，函数 f 都只会被调用一次，等待 f 调用结束了，返回返回值或错误
 func (g *Group) Do(key string, f func() (interface{}, error)) (interface{}, error) {
+	return g.DoContext(context.Background(), key, f)
+}
+
+// 和 Do 一样，但是每个调用者（包括发起请求的调用者）都会响应自己的 ctx 的取消和超时，
+// 提前返回 ctx.Err()，正在进行的请求不受影响，其他调用者仍然能得到结果
+// f 在单独的 goroutine 中执行，不能依赖任何一个调用者的 ctx
+func (g *Group) DoContext(ctx context.Context, key string, f func() (interface{}, error)) (interface{}, error) {
 	g.mutex.Lock()
 	if g.m == nil {
 		g.m = make(map[string]*call)
 	}
-	if c, ok := g.m[key]; ok {
-		//如果请求正在进行中，则等待
-		//保证所有的请求都只会被调用一次，可以重复返回结果
-		c.wg.Wait()
-		//请求结束，返回结果
-		return c.val, c.err
+	c, ok := g.m[key]
+	if ok {
+		g.dups.Add(1)
+	} else {
+		c = &call{done: make(chan struct{})}
+		//添加到 g.m，表明 key 已经有对应的请求在处理
+		g.m[key] = c
+		//调用 f，发起请求
+		go g.run(key, c, f)
 	}
-	c := new(call)
-	//发起请求之前加锁
-	c.wg.Add(1)
-	//添加到 g.m，表明 key 已经有对应的请求在处理
-	g.m[key] = c
 	g.mutex.Unlock()
-	//调用 f，发起请求
+	//保证所有的请求都只会被调用一次，可以重复返回结果
+	select {
+	case <-c.done:
+		//请求结束，返回结果
+		return c.result()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// f 在单独的 goroutine 中执行，panic 不能直接抛出，否则整个进程都会退出，
+// 这里 recover 之后交给等待结果的调用者重新 panic
+func (g *Group) run(key string, c *call, f func() (interface{}, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.panicked = &panicError{value: r, stack: debug.Stack()}
+		}
+		//请求结束
+		close(c.done)
+		g.mutex.Lock()
+		// 更新 g.m
+		delete(g.m, key)
+		g.mutex.Unlock()
+	}()
 	c.val, c.err = f()
-	//请求结束
-	c.wg.Done()
+}
+
+// 一个 key 的结果
+type Result struct {
+	Val interface{}
+	Err error
+}
+
+// 批量版本的 DoContext
+// 已经有请求在进行中的 key 等待它的结果，其余的 key 合并成一次 f 调用，
+// 期间其他调用者对这些 key 的 Do 会等待 f 的结果。
+// f 需要为传入的每个 key 返回结果，缺少的 key 得到一个错误
+func (g *Group) DoMany(ctx context.Context, keys []string, f func(keys []string) map[string]Result) map[string]Result {
 	g.mutex.Lock()
-	// 更新 g.m
-	delete(g.m, key)
-	// 返回结果
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	waiting := make(map[string]*call)
+	owned := make(map[string]*call)
+	var mine []string
+	for _, key := range keys {
+		if _, ok := waiting[key]; ok {
+			continue
+		}
+		if _, ok := owned[key]; ok {
+			continue
+		}
+		if c, ok := g.m[key]; ok {
+			g.dups.Add(1)
+			waiting[key] = c
+			continue
+		}
+		c := &call{done: make(chan struct{})}
+		g.m[key] = c
+		owned[key] = c
+		mine = append(mine, key)
+	}
 	g.mutex.Unlock()
 
-	return c.val, c.err
+	results := make(map[string]Result, len(keys))
+	if len(mine) > 0 {
+		res := g.runMany(mine, owned, f)
+		g.mutex.Lock()
+		for _, key := range mine {
+			r, ok := res[key]
+			if !ok {
+				r.Err = fmt.Errorf("singleflight: no result for key %q", key)
+			}
+			c := owned[key]
+			c.val, c.err = r.Val, r.Err
+			close(c.done)
+			delete(g.m, key)
+			results[key] = r
+		}
+		g.mutex.Unlock()
+	}
+	for key, c := range waiting {
+		select {
+		case <-c.done:
+			val, err := c.result()
+			results[key] = Result{Val: val, Err: err}
+		case <-ctx.Done():
+			results[key] = Result{Err: ctx.Err()}
+		}
+	}
+	return results
+}
 
+// 在调用者的 goroutine 中执行 f，f panic 时先结束 owned 中的请求，
+// 让等待这些 key 的调用者也得到 panic，而不是一直阻塞，然后继续向上 panic
+func (g *Group) runMany(mine []string, owned map[string]*call, f func(keys []string) map[string]Result) map[string]Result {
+	normal := false
+	defer func() {
+		if normal {
+			return
+		}
+		p := &panicError{value: recover(), stack: debug.Stack()}
+		g.mutex.Lock()
+		for _, key := range mine {
+			c := owned[key]
+			c.panicked = p
+			close(c.done)
+			delete(g.m, key)
+		}
+		g.mutex.Unlock()
+		panic(p)
+	}()
+	res := f(mine)
+	normal = true
+	return res
 }
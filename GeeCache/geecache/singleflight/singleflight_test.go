@@ -0,0 +1,156 @@
+package singleflight
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	var g Group
+	var calls int32
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := g.Do("key", func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return "bar", nil
+			})
+			if err != nil || v.(string) != "bar" {
+				t.Errorf("Do = %v, %v", v, err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 || g.Dups() != 9 {
+		t.Fatalf("f should be called once and 9 calls merged, got %d calls and %d dups", calls, g.Dups())
+	}
+}
+
+// 等待中的调用者超时后提前返回，不影响正在进行的请求
+func TestDoContext(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	done := make(chan interface{})
+	go func() {
+		v, _ := g.Do("key", func() (interface{}, error) {
+			<-release
+			return "bar", nil
+		})
+		done <- v
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := g.DoContext(ctx, "key", func() (interface{}, error) {
+		t.Fatal("f should not be called while a call is in flight")
+		return nil, nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expect %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	close(release)
+	if v := <-done; v.(string) != "bar" {
+		t.Fatalf("in flight call should not be affected, got %v", v)
+	}
+}
+
+// 发起请求的调用者超时后提前返回，其他调用者仍然得到结果
+func TestDoContextOwner(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	owner := make(chan error)
+	go func() {
+		_, err := g.DoContext(ctx, "key", func() (interface{}, error) {
+			<-release
+			return "bar", nil
+		})
+		owner <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+	waiter := make(chan interface{})
+	go func() {
+		v, _ := g.Do("key", func() (interface{}, error) {
+			t.Error("f should not be called while a call is in flight")
+			return nil, nil
+		})
+		waiter <- v
+	}()
+
+	if err := <-owner; err != context.DeadlineExceeded {
+		t.Fatalf("expect %v, got %v", context.DeadlineExceeded, err)
+	}
+	close(release)
+	if v := <-waiter; v != "bar" {
+		t.Fatalf("waiter should get the result after the owner gave up, got %v", v)
+	}
+}
+
+// f panic 不会让进程退出，发起请求的调用者和等待的调用者都会重新 panic
+func TestDoPanic(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	panics := make(chan interface{}, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer func() { panics <- recover() }()
+			g.Do("key", func() (interface{}, error) {
+				<-release
+				panic("boom")
+			})
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	for i := 0; i < 2; i++ {
+		p, ok := (<-panics).(*panicError)
+		if !ok || p.value != "boom" {
+			t.Fatalf("every caller should panic with the value of f, got %v", p)
+		}
+	}
+	if v, err := g.Do("key", func() (interface{}, error) { return "bar", nil }); err != nil || v != "bar" {
+		t.Fatalf("key should be callable again after a panic, got %v, %v", v, err)
+	}
+}
+
+// 正在进行中的 key 等待已有的请求，其余的 key 合并成一次调用
+func TestDoMany(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	go g.Do("a", func() (interface{}, error) {
+		<-release
+		return "a-single", nil
+	})
+	time.Sleep(10 * time.Millisecond)
+
+	var batches [][]string
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	res := g.DoMany(context.Background(), []string{"a", "b", "c", "b"}, func(keys []string) map[string]Result {
+		batches = append(batches, keys)
+		return map[string]Result{"b": {Val: "b-batch"}}
+	})
+	if len(batches) != 1 || len(batches[0]) != 2 {
+		t.Fatalf("expect one batch of b and c, got %v", batches)
+	}
+	if res["a"].Val != "a-single" || res["b"].Val != "b-batch" {
+		t.Fatalf("unexpected results %v", res)
+	}
+	if res["c"].Err == nil {
+		t.Fatalf("key missing from the batch result should get an error")
+	}
+}
@@ -0,0 +1,177 @@
+// 把 mainCache 保存到快照，节点重启后从快照恢复，避免所有请求同时回源
+//
+// 快照格式（版本 1）：
+//
+//	magic    8 字节 "GEECACHE"
+//	version  uint16，大端
+//	count    uvarint，记录个数
+//	记录     key 长度 uvarint、key、value 长度 uvarint、value、过期时间 varint（UnixNano，0 表示永不过期）
+//	checksum uint32，大端，前面所有字节的 CRC-32C
+//
+// 记录按淘汰顺序排列，最先被淘汰的在前，恢复时依次写入即可还原 LRU 顺序。
+
+package geecache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"geecache/policy"
+	"hash"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+const (
+	snapshotMagic   = "GEECACHE"
+	snapshotVersion = 1
+	//单个 key 或 value 的长度上限，防止损坏的快照导致分配过大的内存
+	maxSnapshotField = 1 << 30
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// 把 mainCache 中未过期的记录写入 w
+// 每个分片单独加锁复制，快照期间其他分片的读写不受影响
+func (g *Group) Snapshot(w io.Writer) error {
+	entries, ok := g.mainCache.entries(time.Now())
+	if !ok {
+		return fmt.Errorf("geecache: eviction policy of group %s does not support snapshot", g.name)
+	}
+	crc := crc32.New(crcTable)
+	bw := bufio.NewWriter(w)
+	mw := io.MultiWriter(bw, crc)
+
+	buf := make([]byte, 0, 64)
+	buf = append(buf, snapshotMagic...)
+	buf = binary.BigEndian.AppendUint16(buf, snapshotVersion)
+	buf = binary.AppendUvarint(buf, uint64(len(entries)))
+	if _, err := mw.Write(buf); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		//快照中保存解压后的值，换了压缩算法之后也能恢复
+		value, err := g.decode(e.value)
+		if err != nil {
+			return err
+		}
+		buf = binary.AppendUvarint(buf[:0], uint64(len(e.key)))
+		buf = append(buf, e.key...)
+		buf = binary.AppendUvarint(buf, uint64(len(value.b)))
+		if _, err := mw.Write(buf); err != nil {
+			return err
+		}
+		if _, err := mw.Write(value.b); err != nil {
+			return err
+		}
+		var expire int64
+		if !value.e.IsZero() {
+			expire = value.e.UnixNano()
+		}
+		if _, err := mw.Write(binary.AppendVarint(buf[:0], expire)); err != nil {
+			return err
+		}
+	}
+	if _, err := bw.Write(binary.BigEndian.AppendUint32(buf[:0], crc.Sum32())); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// 从 r 读取 Snapshot 写入的快照，并按原来的顺序写入 mainCache
+// 整个快照校验通过之后才会写入，已经过期的记录会被跳过
+func (g *Group) Restore(r io.Reader) error {
+	br := bufio.NewReader(r)
+	cr := &checksumReader{r: br, h: crc32.New(crcTable)}
+
+	header := make([]byte, len(snapshotMagic)+2)
+	if _, err := io.ReadFull(cr, header); err != nil {
+		return fmt.Errorf("%w: reading header: %v", ErrBadSnapshot, err)
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return fmt.Errorf("%w: wrong magic", ErrBadSnapshot)
+	}
+	if v := binary.BigEndian.Uint16(header[len(snapshotMagic):]); v != snapshotVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrBadSnapshot, v)
+	}
+	count, err := binary.ReadUvarint(cr)
+	if err != nil {
+		return fmt.Errorf("%w: reading count: %v", ErrBadSnapshot, err)
+	}
+	var entries []cacheEntry
+	for i := uint64(0); i < count; i++ {
+		key, err := readField(cr)
+		if err != nil {
+			return fmt.Errorf("%w: reading key: %v", ErrBadSnapshot, err)
+		}
+		value, err := readField(cr)
+		if err != nil {
+			return fmt.Errorf("%w: reading value: %v", ErrBadSnapshot, err)
+		}
+		expire, err := binary.ReadVarint(cr)
+		if err != nil {
+			return fmt.Errorf("%w: reading expire: %v", ErrBadSnapshot, err)
+		}
+		e := cacheEntry{key: string(key), value: ByteView{b: value}}
+		if expire != 0 {
+			e.value.e = time.Unix(0, expire)
+		}
+		entries = append(entries, e)
+	}
+	sum := cr.h.Sum32()
+	trailer := make([]byte, 4)
+	if _, err := io.ReadFull(br, trailer); err != nil {
+		return fmt.Errorf("%w: reading checksum: %v", ErrBadSnapshot, err)
+	}
+	if binary.BigEndian.Uint32(trailer) != sum {
+		return fmt.Errorf("%w: checksum mismatch", ErrBadSnapshot)
+	}
+
+	now := time.Now()
+	for _, e := range entries {
+		if policy.Expired(e.value.e, now) {
+			continue
+		}
+		if err := g.populateCache(e.key, e.value, e.value.e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 读取 uvarint 长度前缀的字段
+func readField(r *checksumReader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxSnapshotField {
+		return nil, fmt.Errorf("field too large: %d", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// 读取的同时计算校验和
+type checksumReader struct {
+	r *bufio.Reader
+	h hash.Hash32
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.h.Write(p[:n])
+	return n, err
+}
+
+func (c *checksumReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.h.Write([]byte{b})
+	}
+	return b, err
+}
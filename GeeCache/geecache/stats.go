@@ -0,0 +1,93 @@
+package geecache
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// 可以并发累加的计数器
+type AtomicInt int64
+
+func (i *AtomicInt) Add(n int64) {
+	atomic.AddInt64((*int64)(i), n)
+}
+
+func (i *AtomicInt) Get() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
+func (i *AtomicInt) String() string {
+	return strconv.FormatInt(i.Get(), 10)
+}
+
+// Group 内部使用的计数器
+type groupStats struct {
+	//所有 Get 请求
+	gets AtomicInt
+	//mainCache 或 hotCache 命中
+	hits AtomicInt
+	//negCache 命中，直接返回 ErrNotFound
+	negativeHits AtomicInt
+	//第二级缓存命中
+	diskHits AtomicInt
+	//缓存未命中，需要 load
+	misses AtomicInt
+	//从远程节点获取成功/失败
+	peerLoads  AtomicInt
+	peerErrors AtomicInt
+	//主节点不可用，从其他副本获取成功，包含在 peerLoads 中
+	replicaLoads AtomicInt
+	//调用 Getter 成功/失败
+	localLoads     AtomicInt
+	localLoadErrs  AtomicInt
+	serverRequests AtomicInt
+}
+
+// Group 的统计信息快照
+type Stats struct {
+	Gets   int64
+	Hits   int64
+	Misses int64
+	//negCache 命中的次数
+	NegativeHits int64
+	//第二级缓存命中的次数
+	DiskHits int64
+	//从远程节点获取成功/失败的次数
+	PeerLoads  int64
+	PeerErrors int64
+	//主节点不可用时从其他副本获取成功的次数，包含在 PeerLoads 中
+	ReplicaLoads int64
+	//调用 Getter 成功/失败的次数
+	LocalLoads    int64
+	LocalLoadErrs int64
+	//被 singleflight 合并掉的 load 次数
+	Dedups int64
+	//远程节点发来的请求
+	ServerRequests int64
+	//mainCache 和 hotCache 合计
+	Evictions int64
+	Bytes     int64
+	Items     int64
+}
+
+// 返回 Group 的统计信息
+func (g *Group) Stats() Stats {
+	main, hot := g.mainCache.stats(), g.hotCache.stats()
+	return Stats{
+		Gets:           g.stats.gets.Get(),
+		Hits:           g.stats.hits.Get(),
+		Misses:         g.stats.misses.Get(),
+		NegativeHits:   g.stats.negativeHits.Get(),
+		DiskHits:       g.stats.diskHits.Get(),
+		PeerLoads:      g.stats.peerLoads.Get(),
+		PeerErrors:     g.stats.peerErrors.Get(),
+		ReplicaLoads:   g.stats.replicaLoads.Get(),
+		LocalLoads:     g.stats.localLoads.Get(),
+		LocalLoadErrs:  g.stats.localLoadErrs.Get(),
+		Dedups:         g.loader.Dups(),
+		ServerRequests: g.stats.serverRequests.Get(),
+		Evictions:      main.Evictions + hot.Evictions,
+		Bytes:          main.Bytes + hot.Bytes,
+		Items:          main.Items + hot.Items,
+	}
+}
@@ -0,0 +1,89 @@
+package geecache
+
+import (
+	"errors"
+	"fmt"
+	"geecache/policy"
+	"log"
+	"time"
+)
+
+// 第二级缓存中的值的第一个字节，标记后面的数据是否压缩过
+// 压缩过的值接着保存压缩算法名的长度（1 字节）和名字，重启之后换了压缩算法或者关闭压缩也能读出来
+const (
+	diskRaw byte = iota
+	diskCompressed
+)
+
+// 把 mainCache 因为容量不足淘汰的记录写入第二级缓存
+// 过期和主动删除的记录不需要保存
+func (g *Group) spillToDisk() {
+	onEvicted := g.mainCache.onEvicted
+	g.mainCache.onEvicted = func(key string, value ByteView, reason policy.EvictReason) {
+		if reason == policy.EvictCapacity || reason == policy.EvictRejected {
+			//回调时持有分片的锁，Put 只把记录追加到文件末尾，不 fsync，
+			//文件压缩在 disk.Store 的后台 goroutine 中进行，不会阻塞这里
+			header := []byte{diskRaw}
+			if value.z {
+				name := g.compressor.Name()
+				header = append([]byte{diskCompressed, byte(len(name))}, name...)
+			}
+			if err := g.diskTier.Put(key, append(header, value.b...), value.e); err != nil {
+				log.Printf("[GeeCache] failed to spill %s to disk: %v", key, err)
+			}
+		}
+		if onEvicted != nil {
+			onEvicted(key, value, reason)
+		}
+	}
+}
+
+// 在第二级缓存中查找，找到后移回 mainCache
+// 返回的值可能是压缩后的
+func (g *Group) getFromDisk(key string) (ByteView, bool) {
+	if g.diskTier == nil {
+		return ByteView{}, false
+	}
+	b, expire, ok, err := g.diskTier.Get(key)
+	if err != nil {
+		log.Printf("[GeeCache] failed to read %s from disk: %v", key, err)
+		return ByteView{}, false
+	}
+	if !ok || len(b) == 0 {
+		return ByteView{}, false
+	}
+	value, err := g.fromDisk(b, expire)
+	if err != nil {
+		log.Printf("[GeeCache] failed to read %s from disk: %v", key, err)
+		return ByteView{}, false
+	}
+	g.stats.diskHits.Add(1)
+	//populateCache 会删除第二级缓存中的记录
+	if err = g.populateCache(key, value, expire); err != nil {
+		log.Printf("[GeeCache] failed to populate %s: %v", key, err)
+	}
+	return value, true
+}
+
+// 解析第二级缓存中的值，压缩算法和当前的不同时先解压，再按当前的配置压缩
+func (g *Group) fromDisk(b []byte, expire time.Time) (ByteView, error) {
+	if b[0] == diskRaw {
+		return ByteView{b: b[1:], e: expire}, nil
+	}
+	if b[0] != diskCompressed || len(b) < 2 || len(b) < 2+int(b[1]) {
+		return ByteView{}, errors.New("malformed record")
+	}
+	name, data := string(b[2:2+int(b[1])]), b[2+int(b[1]):]
+	if g.compressor != nil && g.compressor.Name() == name {
+		return ByteView{b: data, e: expire, z: true}, nil
+	}
+	c, ok := CompressorByName(name)
+	if !ok {
+		return ByteView{}, fmt.Errorf("unknown compressor %q", name)
+	}
+	plain, err := c.Decompress(data, g.maxValueSize)
+	if err != nil {
+		return ByteView{}, err
+	}
+	return g.encode(ByteView{b: plain, e: expire}), nil
+}
@@ -0,0 +1,80 @@
+package tinylfu
+
+import "hash/fnv"
+
+const (
+	//哈希函数（行）的个数
+	sketchDepth = 4
+	//计数器的上限，和 4 bit 计数器保持一致
+	maxCount = 15
+)
+
+// count-min sketch，用很小的内存近似统计每个 key 的访问频次
+// 累计访问次数达到 sampleSize 后所有计数减半，让旧的热点逐渐冷却
+type sketch struct {
+	rows       [sketchDepth][]uint8
+	mask       uint32
+	additions  int
+	sampleSize int
+}
+
+// width 会被向上取整为 2 的幂
+func newSketch(width int) *sketch {
+	w := 16
+	for w < width {
+		w <<= 1
+	}
+	s := &sketch{
+		mask:       uint32(w - 1),
+		sampleSize: 10 * w,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, w)
+	}
+	return s
+}
+
+// 用两个哈希值组合出每一行的下标
+func (s *sketch) indexes(key string) [sketchDepth]uint32 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)
+	var idx [sketchDepth]uint32
+	for i := range idx {
+		idx[i] = (h1 + uint32(i)*h2) & s.mask
+	}
+	return idx
+}
+
+func (s *sketch) increment(key string) {
+	for i, j := range s.indexes(key) {
+		if s.rows[i][j] < maxCount {
+			s.rows[i][j]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.sampleSize {
+		s.reset()
+	}
+}
+
+// 取所有行中最小的计数作为估计值
+func (s *sketch) estimate(key string) uint8 {
+	min := uint8(maxCount)
+	for i, j := range s.indexes(key) {
+		if s.rows[i][j] < min {
+			min = s.rows[i][j]
+		}
+	}
+	return min
+}
+
+func (s *sketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
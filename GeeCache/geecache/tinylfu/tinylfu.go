@@ -0,0 +1,256 @@
+// W-TinyLFU 淘汰策略（Einziger, Friedman & Manes）。
+// 新记录先进入很小的窗口 LRU，被挤出窗口后作为候选者，
+// 只有当它的访问频次（count-min sketch 估计）高于主缓存中将被淘汰的记录时才被接纳，
+// 否则直接丢弃。主缓存是分段 LRU：probation 保存新接纳的记录，再次命中后进入 protected。
+// 扫描流量的访问频次很低，无法通过准入检查，因此不会把热点挤出主缓存。
+
+package tinylfu
+
+import (
+	"container/list"
+	"geecache/policy"
+	"time"
+)
+
+const (
+	//窗口占总内存的比例
+	windowRatio = 0.01
+	//protected 占主缓存的比例
+	protectedRatio = 0.8
+	//sketch 的宽度按照平均每条记录 64 字节估计
+	avgEntryBytes = 64
+	//不限制内存时 sketch 的宽度
+	defaultSketchWidth = 1 << 12
+)
+
+// 记录所在的分段
+type segment int
+
+const (
+	window segment = iota
+	probation
+	protected
+)
+
+type Cache struct {
+	maxBytes     int64
+	windowMax    int64
+	protectedMax int64
+	mainMax      int64
+
+	lists [3]*list.List
+	bytes [3]int64
+
+	cache  map[string]*list.Element
+	sketch *sketch
+	//某条记录被移除时的回调函数
+	OnEvicted policy.EvictFunc
+}
+
+type entry struct {
+	key     string
+	value   policy.Value
+	expire  time.Time
+	segment segment
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.key)) + int64(e.value.Len())
+}
+
+func New(maxBytes int64, onEvicted policy.EvictFunc) *Cache {
+	width := defaultSketchWidth
+	if maxBytes != 0 {
+		width = int(maxBytes / avgEntryBytes)
+	}
+	c := &Cache{
+		cache:     make(map[string]*list.Element),
+		sketch:    newSketch(width),
+		OnEvicted: onEvicted,
+	}
+	for i := range c.lists {
+		c.lists[i] = list.New()
+	}
+	c.setMaxBytes(maxBytes)
+	return c
+}
+
+// 按比例划分窗口、主缓存和 protected 的份额
+func (c *Cache) setMaxBytes(maxBytes int64) {
+	c.maxBytes = maxBytes
+	c.windowMax = int64(float64(maxBytes) * windowRatio)
+	c.mainMax = maxBytes - c.windowMax
+	c.protectedMax = int64(float64(c.mainMax) * protectedRatio)
+}
+
+// 原地调整内存上限，保留 sketch 中的访问频次和各分段中的位置
+// 缩小时 protected 中最久未访问的记录先降级到 probation，再按正常的顺序淘汰，
+// 热点记录不会因为重建而被准入检查拒绝
+func (c *Cache) Resize(maxBytes int64) {
+	c.setMaxBytes(maxBytes)
+	if maxBytes == 0 {
+		return
+	}
+	for c.bytes[protected] > c.protectedMax && c.lists[protected].Len() > 0 {
+		c.move(c.lists[protected].Back(), probation)
+	}
+	c.evict()
+}
+
+// 满足 policy.Factory
+func NewPolicy(maxBytes int64, onEvicted policy.EvictFunc) policy.Policy {
+	return New(maxBytes, onEvicted)
+}
+
+var _ policy.Policy = (*Cache)(nil)
+var _ policy.Ranger = (*Cache)(nil)
+var _ policy.Resizer = (*Cache)(nil)
+
+func (c *Cache) Len() int {
+	return len(c.cache)
+}
+
+func (c *Cache) Bytes() int64 {
+	return c.bytes[window] + c.mainBytes()
+}
+
+func (c *Cache) Get(key string) (value policy.Value, ok bool) {
+	c.sketch.increment(key)
+	ele, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	e := ele.Value.(*entry)
+	if policy.Expired(e.expire, time.Now()) {
+		c.removeElement(ele, policy.EvictExpired)
+		return nil, false
+	}
+	c.touch(ele)
+	return e.value, true
+}
+
+func (c *Cache) AddWithExpire(key string, value policy.Value, expire time.Time) {
+	c.sketch.increment(key)
+	if ele, ok := c.cache[key]; ok {
+		e := ele.Value.(*entry)
+		c.bytes[e.segment] += int64(value.Len()) - int64(e.value.Len())
+		e.value = value
+		e.expire = expire
+		c.touch(ele)
+	} else {
+		e := &entry{key: key, value: value, expire: expire, segment: window}
+		c.cache[key] = c.lists[window].PushFront(e)
+		c.bytes[window] += e.size()
+	}
+	c.evict()
+}
+
+func (c *Cache) RemoveKey(key string) bool {
+	ele, ok := c.cache[key]
+	if ok {
+		c.removeElement(ele, policy.EvictRemoved)
+	}
+	return ok
+}
+
+func (c *Cache) RemoveExpired(now time.Time) int {
+	n := 0
+	for _, ele := range c.cache {
+		if policy.Expired(ele.Value.(*entry).expire, now) {
+			c.removeElement(ele, policy.EvictExpired)
+			n++
+		}
+	}
+	return n
+}
+
+// 命中时调整记录的位置，probation 中的记录晋升到 protected
+func (c *Cache) touch(ele *list.Element) {
+	e := ele.Value.(*entry)
+	if e.segment != probation {
+		c.lists[e.segment].MoveToFront(ele)
+		return
+	}
+	c.move(ele, protected)
+	//protected 超出份额时，把最久未访问的记录降级回 probation
+	for c.bytes[protected] > c.protectedMax && c.lists[protected].Len() > 1 {
+		c.move(c.lists[protected].Back(), probation)
+	}
+}
+
+// 把记录移动到另一个分段的头部
+func (c *Cache) move(ele *list.Element, to segment) {
+	e := ele.Value.(*entry)
+	c.lists[e.segment].Remove(ele)
+	c.bytes[e.segment] -= e.size()
+	e.segment = to
+	c.cache[e.key] = c.lists[to].PushFront(e)
+	c.bytes[to] += e.size()
+}
+
+func (c *Cache) mainBytes() int64 {
+	return c.bytes[probation] + c.bytes[protected]
+}
+
+// 主缓存中下一个被淘汰的记录，优先从 probation 中选
+func (c *Cache) victim() *list.Element {
+	if ele := c.lists[probation].Back(); ele != nil {
+		return ele
+	}
+	return c.lists[protected].Back()
+}
+
+func (c *Cache) evict() {
+	if c.maxBytes == 0 {
+		return
+	}
+	//更新过的记录可能让主缓存超出上限
+	for c.mainBytes() > c.mainMax {
+		c.removeElement(c.victim(), policy.EvictCapacity)
+	}
+	//窗口溢出的记录作为候选者进入准入检查
+	for c.bytes[window] > c.windowMax {
+		c.admit(c.lists[window].Back())
+	}
+}
+
+func (c *Cache) admit(candidate *list.Element) {
+	e := candidate.Value.(*entry)
+	freq := c.sketch.estimate(e.key)
+	for c.mainBytes()+e.size() > c.mainMax {
+		victim := c.victim()
+		if victim == nil {
+			//记录比整个主缓存还大
+			c.removeElement(candidate, policy.EvictRejected)
+			return
+		}
+		if freq <= c.sketch.estimate(victim.Value.(*entry).key) {
+			c.removeElement(candidate, policy.EvictRejected)
+			return
+		}
+		c.removeElement(victim, policy.EvictCapacity)
+	}
+	c.move(candidate, probation)
+}
+
+func (c *Cache) removeElement(ele *list.Element, reason policy.EvictReason) {
+	e := ele.Value.(*entry)
+	c.lists[e.segment].Remove(ele)
+	c.bytes[e.segment] -= e.size()
+	delete(c.cache, e.key)
+	if c.OnEvicted != nil {
+		c.OnEvicted(e.key, e.value, reason)
+	}
+}
+
+// 依次遍历窗口、试用区和保护区，各自从最早的记录开始
+func (c *Cache) Range(fn func(key string, value policy.Value, expire time.Time) bool) {
+	for _, l := range c.lists {
+		for ele := l.Back(); ele != nil; ele = ele.Prev() {
+			e := ele.Value.(*entry)
+			if !fn(e.key, e.value, e.expire) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package tinylfu
+
+import (
+	"fmt"
+	"geecache/policy"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (s String) Len() int {
+	return len(s)
+}
+
+func TestGet(t *testing.T) {
+	c := New(int64(0), nil)
+	c.AddWithExpire("key1", String("1234"), time.Time{})
+	if v, ok := c.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+	if _, ok := c.Get("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+}
+
+// 扫描流量的访问频次低，无法通过准入检查
+func TestAdmission(t *testing.T) {
+	rejected := 0
+	c := New(int64(2000), func(key string, value policy.Value, reason policy.EvictReason) {
+		if reason == policy.EvictRejected {
+			rejected++
+		}
+	})
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 50; i++ {
+			key := fmt.Sprintf("hot%02d", i)
+			if _, ok := c.Get(key); !ok {
+				c.AddWithExpire(key, String("0123456789"), time.Time{})
+			}
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		c.AddWithExpire(fmt.Sprintf("scan%04d", i), String("0123456789"), time.Time{})
+	}
+
+	hits := 0
+	for i := 0; i < 50; i++ {
+		if _, ok := c.Get(fmt.Sprintf("hot%02d", i)); ok {
+			hits++
+		}
+	}
+	if hits < 45 {
+		t.Fatalf("only %d of 50 hot keys survived the scan", hits)
+	}
+	if rejected == 0 {
+		t.Fatalf("scan keys should be rejected by admission")
+	}
+	if used := c.bytes[window] + c.mainBytes(); used > 2000 {
+		t.Fatalf("cache uses %d bytes, more than 2000", used)
+	}
+}
+
+func TestSketch(t *testing.T) {
+	s := newSketch(64)
+	for i := 0; i < 10; i++ {
+		s.increment("hot")
+	}
+	s.increment("cold")
+	if s.estimate("hot") < 10 || s.estimate("cold") > s.estimate("hot") {
+		t.Fatalf("bad estimate hot=%d cold=%d", s.estimate("hot"), s.estimate("cold"))
+	}
+	s.reset()
+	if s.estimate("hot") != 5 {
+		t.Fatalf("reset should halve counters, got %d", s.estimate("hot"))
+	}
+}
+
+func TestExpire(t *testing.T) {
+	c := New(int64(0), nil)
+	c.AddWithExpire("k1", String("v1"), time.Now().Add(-time.Second))
+	c.AddWithExpire("k2", String("v2"), time.Now().Add(time.Hour))
+	if _, ok := c.Get("k1"); ok {
+		t.Fatalf("expired k1 should miss")
+	}
+	if n := c.RemoveExpired(time.Now().Add(2 * time.Hour)); n != 1 || c.Len() != 0 {
+		t.Fatalf("RemoveExpired should remove k2, removed %d", n)
+	}
+}
+
+// 缩小内存上限时保留访问频次，热点记录留下，只访问过一次的记录先被淘汰
+func TestResize(t *testing.T) {
+	c := New(int64(2000), nil)
+	for i := 0; i < 100; i++ {
+		c.AddWithExpire(fmt.Sprintf("cold%02d", i), String("0123456789"), time.Time{})
+	}
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 20; i++ {
+			key := fmt.Sprintf("hot%02d", i)
+			if _, ok := c.Get(key); !ok {
+				c.AddWithExpire(key, String("0123456789"), time.Time{})
+			}
+		}
+	}
+	c.Resize(500)
+	if used := c.Bytes(); used > 500 {
+		t.Fatalf("cache uses %d bytes after resize, more than 500", used)
+	}
+	for i := 0; i < 20; i++ {
+		if _, ok := c.Get(fmt.Sprintf("hot%02d", i)); !ok {
+			t.Fatalf("hot%02d should survive the resize", i)
+		}
+	}
+}
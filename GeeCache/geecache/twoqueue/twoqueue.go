@@ -0,0 +1,216 @@
+// 2Q 淘汰策略（Johnson & Shasha）。
+// 第一次访问的记录先进入 FIFO 队列 A1in，被挤出后只把 key 留在幽灵队列 A1out；
+// 只有在 A1out 中还能找到的 key 再次写入时，才会进入 LRU 队列 Am。
+// 这样一次性的扫描流量只会在 A1in 中流过，不会把 Am 里的热点挤出去。
+
+package twoqueue
+
+import (
+	"container/list"
+	"geecache/policy"
+	"time"
+)
+
+const (
+	//A1in 占总内存的比例
+	defaultInRatio = 0.25
+	//A1out 记录的幽灵 key 对应的内存占总内存的比例
+	defaultOutRatio = 0.5
+)
+
+type Cache struct {
+	maxBytes int64
+	//A1in 允许使用的内存
+	inBytes int64
+	//A1out 允许记录的内存
+	outBytes int64
+
+	//A1in，front 是最新进入的记录
+	recent      *list.List
+	recentBytes int64
+	//Am，front 是最近访问的记录
+	frequent      *list.List
+	frequentBytes int64
+	//A1out，只保存 key 和记录大小
+	ghost      *list.List
+	ghostBytes int64
+
+	cache  map[string]*list.Element
+	ghosts map[string]*list.Element
+	//某条记录被移除时的回调函数
+	OnEvicted policy.EvictFunc
+}
+
+type entry struct {
+	key    string
+	value  policy.Value
+	expire time.Time
+	//是否在 Am 中
+	frequent bool
+}
+
+type ghostEntry struct {
+	key  string
+	size int64
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.key)) + int64(e.value.Len())
+}
+
+func New(maxBytes int64, onEvicted policy.EvictFunc) *Cache {
+	return &Cache{
+		maxBytes:  maxBytes,
+		inBytes:   int64(float64(maxBytes) * defaultInRatio),
+		outBytes:  int64(float64(maxBytes) * defaultOutRatio),
+		recent:    list.New(),
+		frequent:  list.New(),
+		ghost:     list.New(),
+		cache:     make(map[string]*list.Element),
+		ghosts:    make(map[string]*list.Element),
+		OnEvicted: onEvicted,
+	}
+}
+
+// 满足 policy.Factory
+func NewPolicy(maxBytes int64, onEvicted policy.EvictFunc) policy.Policy {
+	return New(maxBytes, onEvicted)
+}
+
+var _ policy.Policy = (*Cache)(nil)
+var _ policy.Ranger = (*Cache)(nil)
+
+func (c *Cache) Len() int {
+	return len(c.cache)
+}
+
+func (c *Cache) Bytes() int64 {
+	return c.recentBytes + c.frequentBytes
+}
+
+func (c *Cache) Get(key string) (value policy.Value, ok bool) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	e := ele.Value.(*entry)
+	if policy.Expired(e.expire, time.Now()) {
+		c.removeElement(ele, policy.EvictExpired)
+		return nil, false
+	}
+	//A1in 是 FIFO，命中时不调整位置
+	if e.frequent {
+		c.frequent.MoveToFront(ele)
+	}
+	return e.value, true
+}
+
+func (c *Cache) AddWithExpire(key string, value policy.Value, expire time.Time) {
+	if ele, ok := c.cache[key]; ok {
+		e := ele.Value.(*entry)
+		delta := int64(value.Len()) - int64(e.value.Len())
+		e.value = value
+		e.expire = expire
+		if e.frequent {
+			c.frequentBytes += delta
+			c.frequent.MoveToFront(ele)
+		} else {
+			c.recentBytes += delta
+		}
+	} else {
+		e := &entry{key: key, value: value, expire: expire}
+		if g, ok := c.ghosts[key]; ok {
+			//被挤出 A1in 后再次出现，说明是热点，进入 Am
+			c.removeGhost(g)
+			e.frequent = true
+			c.cache[key] = c.frequent.PushFront(e)
+			c.frequentBytes += e.size()
+		} else {
+			c.cache[key] = c.recent.PushFront(e)
+			c.recentBytes += e.size()
+		}
+	}
+	for c.maxBytes != 0 && c.maxBytes < c.recentBytes+c.frequentBytes {
+		c.reclaim()
+	}
+}
+
+func (c *Cache) RemoveKey(key string) bool {
+	ele, ok := c.cache[key]
+	if ok {
+		c.removeElement(ele, policy.EvictRemoved)
+	}
+	return ok
+}
+
+func (c *Cache) RemoveExpired(now time.Time) int {
+	n := 0
+	for _, ele := range c.cache {
+		if policy.Expired(ele.Value.(*entry).expire, now) {
+			c.removeElement(ele, policy.EvictExpired)
+			n++
+		}
+	}
+	return n
+}
+
+// A1in 超出自己的份额时淘汰 A1in，否则淘汰 Am 中最久未访问的记录
+func (c *Cache) reclaim() {
+	if ele := c.recent.Back(); ele != nil && (c.recentBytes > c.inBytes || c.frequent.Len() == 0) {
+		e := ele.Value.(*entry)
+		c.removeElement(ele, policy.EvictCapacity)
+		c.addGhost(e.key, e.size())
+		return
+	}
+	if ele := c.frequent.Back(); ele != nil {
+		c.removeElement(ele, policy.EvictCapacity)
+	}
+}
+
+func (c *Cache) addGhost(key string, size int64) {
+	c.ghosts[key] = c.ghost.PushFront(&ghostEntry{key: key, size: size})
+	c.ghostBytes += size
+	for c.ghostBytes > c.outBytes && c.ghost.Len() > 0 {
+		c.removeGhost(c.ghost.Back())
+	}
+}
+
+func (c *Cache) removeGhost(ele *list.Element) {
+	g := ele.Value.(*ghostEntry)
+	c.ghost.Remove(ele)
+	delete(c.ghosts, g.key)
+	c.ghostBytes -= g.size
+}
+
+func (c *Cache) removeElement(ele *list.Element, reason policy.EvictReason) {
+	e := ele.Value.(*entry)
+	if e.frequent {
+		c.frequent.Remove(ele)
+		c.frequentBytes -= e.size()
+	} else {
+		c.recent.Remove(ele)
+		c.recentBytes -= e.size()
+	}
+	delete(c.cache, e.key)
+	if c.OnEvicted != nil {
+		c.OnEvicted(e.key, e.value, reason)
+	}
+}
+
+// 先遍历 A1in，再遍历 Am，各自从最早的记录开始
+func (c *Cache) Range(fn func(key string, value policy.Value, expire time.Time) bool) {
+	if rangeList(c.recent, fn) {
+		rangeList(c.frequent, fn)
+	}
+}
+
+// 从 back 到 front 遍历链表中的 entry
+func rangeList(l *list.List, fn func(key string, value policy.Value, expire time.Time) bool) bool {
+	for ele := l.Back(); ele != nil; ele = ele.Prev() {
+		e := ele.Value.(*entry)
+		if !fn(e.key, e.value, e.expire) {
+			return false
+		}
+	}
+	return true
+}
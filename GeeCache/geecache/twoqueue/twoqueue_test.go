@@ -0,0 +1,63 @@
+package twoqueue
+
+import (
+	"fmt"
+	"geecache/policy"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (s String) Len() int {
+	return len(s)
+}
+
+func TestGet(t *testing.T) {
+	c := New(int64(0), nil)
+	c.AddWithExpire("key1", String("1234"), time.Time{})
+	if v, ok := c.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+	if _, ok := c.Get("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+}
+
+// 从 A1out 中回来的 key 进入 Am，之后的扫描流量不会把它淘汰
+func TestScanResistant(t *testing.T) {
+	evicted := make(map[string]policy.EvictReason)
+	c := New(int64(400), func(key string, value policy.Value, reason policy.EvictReason) {
+		evicted[key] = reason
+	})
+	c.AddWithExpire("hot", String("0123456789"), time.Time{})
+	for i := 0; i < 30; i++ {
+		c.AddWithExpire(fmt.Sprintf("warm%02d", i), String("0123456789"), time.Time{})
+	}
+	if _, ok := c.Get("hot"); ok {
+		t.Fatalf("hot should have been pushed out of A1in")
+	}
+	c.AddWithExpire("hot", String("0123456789"), time.Time{})
+
+	for i := 0; i < 1000; i++ {
+		c.AddWithExpire(fmt.Sprintf("scan%04d", i), String("0123456789"), time.Time{})
+	}
+	if _, ok := c.Get("hot"); !ok {
+		t.Fatalf("hot key was flushed by scan, reason %v", evicted["hot"])
+	}
+	if c.recentBytes+c.frequentBytes > 400 {
+		t.Fatalf("cache uses %d bytes, more than 400", c.recentBytes+c.frequentBytes)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	c := New(int64(0), nil)
+	c.AddWithExpire("k1", String("v1"), time.Now().Add(-time.Second))
+	c.AddWithExpire("k2", String("v2"), time.Now().Add(time.Hour))
+	if _, ok := c.Get("k1"); ok {
+		t.Fatalf("expired k1 should miss")
+	}
+	if n := c.RemoveExpired(time.Now().Add(2 * time.Hour)); n != 1 || c.Len() != 0 {
+		t.Fatalf("RemoveExpired should remove k2, removed %d", n)
+	}
+}
@@ -0,0 +1,144 @@
+package geecache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"reflect"
+)
+
+// 在 T 和缓存中保存的 []byte 之间转换
+// Unmarshal 不能在返回之后继续引用 data，data 是缓存中的只读数据
+type Codec[T any] interface {
+	Marshal(v T) ([]byte, error)
+	Unmarshal(data []byte) (T, error)
+}
+
+// 使用 encoding/json 编码
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Marshal(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec[T]) Unmarshal(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// 使用 encoding/gob 编码，每个值都带有类型信息，适合 Go 服务之间使用
+type GobCodec[T any] struct{}
+
+func (GobCodec[T]) Marshal(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec[T]) Unmarshal(data []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v, err
+}
+
+// 使用 protobuf 编码，T 是生成的消息的指针类型，例如 *geecachepb.Response
+type ProtoCodec[T proto.Message] struct{}
+
+func (ProtoCodec[T]) Marshal(v T) ([]byte, error) {
+	return proto.Marshal(v)
+}
+
+func (ProtoCodec[T]) Unmarshal(data []byte) (T, error) {
+	//T 是指针类型，创建它指向的新消息
+	var zero T
+	v := reflect.New(reflect.TypeOf(zero).Elem()).Interface().(T)
+	err := proto.Unmarshal(data, v)
+	return v, err
+}
+
+// 回源函数，返回 T 而不是 []byte
+type TypedGetterFunc[T any] func(ctx context.Context, key string) (T, error)
+
+// 带类型的 Group，编解码由 Codec 完成，调用者不需要自己处理 ByteView
+type TypedGroup[T any] struct {
+	group *Group
+	codec Codec[T]
+}
+
+// 在默认的 Cache 中创建一个 Group，并用 codec 在 T 和 []byte 之间转换
+func NewTypedGroup[T any](name string, bytes int64, codec Codec[T], getter TypedGetterFunc[T], opts ...GroupOption) *TypedGroup[T] {
+	return NewTypedGroupIn(defaultCache, name, bytes, codec, getter, opts...)
+}
+
+// 和 NewTypedGroup 一样，Group 创建在 c 中
+func NewTypedGroupIn[T any](c *Cache, name string, bytes int64, codec Codec[T], getter TypedGetterFunc[T], opts ...GroupOption) *TypedGroup[T] {
+	if getter == nil {
+		panic("nil Getter")
+	}
+	g := c.NewGroup(name, bytes, ContextGetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		v, err := getter(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		return codec.Marshal(v)
+	}), opts...)
+	return &TypedGroup[T]{group: g, codec: codec}
+}
+
+// 返回底层的 Group，用于注册节点、查看统计信息等
+func (t *TypedGroup[T]) Group() *Group {
+	return t.group
+}
+
+func (t *TypedGroup[T]) Get(ctx context.Context, key string) (T, error) {
+	view, err := t.group.GetContext(ctx, key)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return t.decode(key, view)
+}
+
+// 批量获取，和 Group.GetMany 一样，不存在的 key 不会出现在结果中
+func (t *TypedGroup[T]) GetMany(ctx context.Context, keys []string) (map[string]T, error) {
+	views, err := t.group.GetMany(ctx, keys)
+	values := make(map[string]T, len(views))
+	for key, view := range views {
+		v, decodeErr := t.decode(key, view)
+		if decodeErr != nil {
+			if err == nil {
+				err = decodeErr
+			}
+			continue
+		}
+		values[key] = v
+	}
+	return values, err
+}
+
+func (t *TypedGroup[T]) Set(key string, v T) error {
+	b, err := t.codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("geecache: encoding %s: %w", key, err)
+	}
+	return t.group.Set(key, b)
+}
+
+func (t *TypedGroup[T]) Remove(key string) error {
+	return t.group.Remove(key)
+}
+
+// ByteView 是只读的，直接使用底层的切片，不需要复制
+func (t *TypedGroup[T]) decode(key string, view ByteView) (T, error) {
+	v, err := t.codec.Unmarshal(view.b)
+	if err != nil {
+		return v, fmt.Errorf("geecache: decoding %s: %w", key, err)
+	}
+	return v, nil
+}
@@ -1,11 +1,26 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"geecache"
+	"geecache/disk"
+	"geecache/registry"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 var db = map[string]string{
@@ -31,8 +46,38 @@ func main() {
 	//log.Fatal(http.ListenAndServe(addr, peers))
 	var port int
 	var api bool
+	var policy string
+	var timeout time.Duration
+	var transport string
+	var peerList string
+	var registryAddr string
+	var snapshotDir string
+	var snapshotInterval time.Duration
+	var diskDir string
+	var diskBytes int64
+	var compression string
+	var tlsCert, tlsKey, tlsCA string
+	var secretFile string
+	var placement string
+	var replicas int
 	flag.IntVar(&port, "port", 8081, "Geecache server port")
 	flag.BoolVar(&api, "api", false, "start a api server?")
+	flag.StringVar(&policy, "policy", "lru", "eviction policy: lru, lfu, arc, 2q or tinylfu")
+	flag.DurationVar(&timeout, "timeout", 5*time.Second, "timeout of a /api request")
+	flag.StringVar(&transport, "transport", "http", "transport between peers: http or grpc")
+	flag.StringVar(&peerList, "peers", "", "comma separated peer addresses, default is the 3 local nodes")
+	flag.StringVar(&registryAddr, "registry", "", "discover peers from file:<path> or etcd:<endpoint> instead of -peers")
+	flag.StringVar(&snapshotDir, "snapshot-dir", "", "restore the cache from this directory on startup and snapshot to it periodically")
+	flag.DurationVar(&snapshotInterval, "snapshot-interval", time.Minute, "interval between snapshots")
+	flag.StringVar(&diskDir, "disk-dir", "", "spill evicted values to a disk tier in this directory")
+	flag.Int64Var(&diskBytes, "disk-bytes", 1<<30, "maximum bytes of the disk tier, 0 means unlimited")
+	flag.StringVar(&compression, "compress", "", "compress cached values: flate or gzip")
+	flag.StringVar(&tlsCert, "tls-cert", "", "certificate of this node, enables mTLS between peers together with -tls-key and -tls-ca")
+	flag.StringVar(&tlsKey, "tls-key", "", "private key of -tls-cert")
+	flag.StringVar(&tlsCA, "tls-ca", "", "CA certificates used to verify other peers")
+	flag.StringVar(&placement, "placement", "ring", "peer placement algorithm: ring, ring64, rendezvous, jump or maglev")
+	flag.IntVar(&replicas, "replicas", 1, "number of peers holding each key")
+	flag.StringVar(&secretFile, "secret-file", "", "file containing the secret shared by all peers to sign requests")
 	flag.Parse()
 
 	apiAddr := "http://localhost:9999"
@@ -46,44 +91,288 @@ func main() {
 	for _, v := range addrMap {
 		addrs = append(addrs, v)
 	}
+	//新加入的节点通过 -peers 指定整个集群，已有的节点通过管理接口加入新节点：
+	//curl -X POST "http://localhost:8001/_geecache_admin/peers?peer=http://localhost:8004"
+	if peerList != "" {
+		addrs = strings.Split(peerList, ",")
+	}
+	self := fmt.Sprintf("http://localhost:%d", port)
+	poolOpts, err := authOptions(tlsCert, tlsKey, tlsCA, secretFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	newPicker, ok := geecache.PickerByName(placement)
+	if !ok {
+		log.Fatalf("unknown placement %q", placement)
+	}
+	poolOpts = append(poolOpts, geecache.WithPicker(newPicker))
+	if tlsCert != "" {
+		//使用 mTLS 时所有节点的地址都是 https://
+		self = toHTTPS(self)
+		for i, a := range addrs {
+			addrs[i] = toHTTPS(a)
+		}
+	}
 
-	gee := createGroup()
+	opts := []geecache.GroupOption{}
+	if diskDir != "" {
+		//每个节点使用自己的目录，本地同时启动多个节点时不会冲突
+		store, err := disk.Open(filepath.Join(diskDir, strconv.Itoa(port)), diskBytes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer store.Close()
+		opts = append(opts, geecache.WithDiskTier(store))
+	}
+	if compression != "" {
+		c, ok := geecache.CompressorByName(compression)
+		if !ok {
+			log.Fatalf("unknown compression %q", compression)
+		}
+		opts = append(opts, geecache.WithCompression(c))
+	}
+	if replicas > 1 {
+		opts = append(opts, geecache.WithReplicas(replicas))
+	}
+	gee := createGroup(policy, opts...)
+	if snapshotDir != "" {
+		path := filepath.Join(snapshotDir, fmt.Sprintf("%s-%d.snapshot", gee.Name(), port))
+		restoreSnapshot(gee, path)
+		go snapshotLoop(gee, path, snapshotInterval)
+	}
 	if api {
 		//需要命令行传入 port 和 api 2 个参数，用来在指定端口启动 HTTP 服务。
-		go startAPIServer(apiAddr, gee)
+		go startAPIServer(apiAddr, gee, timeout)
+	}
+	switch transport {
+	case "http":
+		startCacheServer(self, []string(addrs), registryAddr, gee, poolOpts...)
+	case "grpc":
+		grpcOpts, err := grpcAuthOptions(tlsCert, tlsKey, tlsCA, secretFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		grpcOpts = append(grpcOpts, geecache.WithGRPCPicker(newPicker))
+		startGRPCCacheServer(self, []string(addrs), registryAddr, gee, grpcOpts...)
+	default:
+		log.Fatalf("unknown transport %q", transport)
 	}
-	startCacheServer(addrMap[port], []string(addrs), gee)
 }
 
-func createGroup() *geecache.Group {
+func createGroup(policy string, opts ...geecache.GroupOption) *geecache.Group {
+	newPolicy, ok := geecache.PolicyByName(policy)
+	if !ok {
+		log.Fatalf("unknown eviction policy %q", policy)
+	}
 	return geecache.NewGroup("scores", 2<<10, geecache.GetterFunc(
 		func(key string) ([]byte, error) {
 			log.Println("[SlowDB] search key", key)
 			if v, ok := db[key]; ok {
 				return []byte(v), nil
 			}
-			return nil, fmt.Errorf("%s not exist", key)
-		}))
+			return nil, fmt.Errorf("%s not exist: %w", key, geecache.ErrNotFound)
+		}), append(opts, geecache.WithEvictionPolicy(newPolicy))...)
+}
+
+// 启动时从快照恢复 mainCache，快照不存在时直接跳过
+func restoreSnapshot(gee *geecache.Group, path string) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Println("[Snapshot] open failed:", err)
+		return
+	}
+	defer f.Close()
+	if err := gee.Restore(f); err != nil {
+		log.Println("[Snapshot] restore failed:", err)
+		return
+	}
+	log.Println("[Snapshot] restored from", path)
+}
+
+// 定期保存快照，收到退出信号时再保存一次
+func snapshotLoop(gee *geecache.Group, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	for {
+		select {
+		case <-ticker.C:
+			if err := writeSnapshot(gee, path); err != nil {
+				log.Println("[Snapshot] failed:", err)
+			}
+		case <-sig:
+			if err := writeSnapshot(gee, path); err != nil {
+				log.Println("[Snapshot] failed:", err)
+			}
+			os.Exit(0)
+		}
+	}
+}
+
+// 先写入临时文件再重命名，进程在写入过程中退出也不会损坏上一次的快照
+func writeSnapshot(gee *geecache.Group, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err = gee.Snapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
 }
 
 // startCacheServer() 用来启动缓存服务器：创建 HTTPPool，添加节点信息，
 // 注册到 gee 中，启动 HTTP 服务（共3个端口，8001/8002/8003），用户不感知。
-func startCacheServer(addr string, addrs []string, gee *geecache.Group) {
-	peers := geecache.NewHTTPPool(addr)
-	peers.Set(addrs...)
+func startCacheServer(addr string, addrs []string, registryAddr string, gee *geecache.Group, opts ...geecache.HTTPPoolOption) {
+	peers := geecache.NewHTTPPool(addr, opts...)
+	if registryAddr == "" {
+		peers.Set(addrs...)
+	} else if err := peers.Discover(context.Background(), newRegistry(registryAddr)); err != nil {
+		log.Fatal(err)
+	}
 	gee.RegisterPeers(peers)
 	log.Println("geecache is running at", addr)
-	log.Fatal(http.ListenAndServe(addr[7:], peers))
+	u, err := url.Parse(addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := &http.Server{Addr: u.Host, Handler: peers, TLSConfig: peers.TLSConfig()}
+	if srv.TLSConfig != nil {
+		//证书已经在 TLSConfig 中
+		log.Fatal(srv.ListenAndServeTLS("", ""))
+	}
+	log.Fatal(srv.ListenAndServe())
+}
+
+// 根据命令行参数创建节点之间认证需要的 HTTPPool 配置
+func authOptions(certFile, keyFile, caFile, secretFile string) ([]geecache.HTTPPoolOption, error) {
+	var opts []geecache.HTTPPoolOption
+	if certFile != "" {
+		cert, roots, err := loadMutualTLS(certFile, keyFile, caFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, geecache.WithMutualTLS(cert, roots))
+	}
+	if secretFile != "" {
+		secret, err := os.ReadFile(secretFile)
+		if err != nil {
+			return nil, err
+		}
+		secret = bytes.TrimSpace(secret)
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("%s is empty", secretFile)
+		}
+		opts = append(opts, geecache.WithSharedSecret(secret))
+	}
+	return opts, nil
+}
+
+// gRPC 只支持 mTLS，请求签名是 HTTP 的请求头，不能用于 gRPC
+func grpcAuthOptions(certFile, keyFile, caFile, secretFile string) ([]geecache.GRPCPoolOption, error) {
+	if secretFile != "" {
+		return nil, fmt.Errorf("-secret-file is not supported with -transport=grpc, use -tls-cert instead")
+	}
+	if certFile == "" {
+		return nil, nil
+	}
+	cert, roots, err := loadMutualTLS(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, err
+	}
+	return []geecache.GRPCPoolOption{geecache.WithGRPCMutualTLS(cert, roots)}, nil
+}
+
+// 读取本节点的证书和信任的 CA
+func loadMutualTLS(certFile, keyFile, caFile string) (tls.Certificate, *x509.CertPool, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return tls.Certificate{}, nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return cert, roots, nil
+}
+
+func toHTTPS(addr string) string {
+	return "https://" + strings.TrimPrefix(addr, "http://")
+}
+
+// 解析 -registry 参数，例如 file:peers.txt 或 etcd:http://127.0.0.1:2379
+func newRegistry(addr string) geecache.Registry {
+	kind, target, _ := strings.Cut(addr, ":")
+	switch kind {
+	case "file":
+		return registry.NewFile(target)
+	case "etcd":
+		return registry.NewEtcd(target)
+	}
+	log.Fatalf("unknown registry %q", addr)
+	return nil
+}
+
+// 和 startCacheServer 一样，但是节点之间使用 gRPC 通信
+// gRPC 的地址不带 http:// 或者 https:// 前缀，注册到 -registry 中的也是这样的地址
+func startGRPCCacheServer(addr string, addrs []string, registryAddr string, gee *geecache.Group, opts ...geecache.GRPCPoolOption) {
+	self := grpcAddr(addr)
+	peerAddrs := make([]string, len(addrs))
+	for i, a := range addrs {
+		peerAddrs[i] = grpcAddr(a)
+	}
+	peers := geecache.NewGRPCPool(self, opts...)
+	if registryAddr == "" {
+		if err := peers.SetPeers(peerAddrs...); err != nil {
+			log.Fatal(err)
+		}
+	} else if err := peers.Discover(context.Background(), newRegistry(registryAddr)); err != nil {
+		log.Fatal(err)
+	}
+	gee.RegisterPeers(peers)
+	lis, err := net.Listen("tcp", self)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("geecache is running at", self, "over grpc")
+	log.Fatal(peers.Serve(lis))
+}
+
+func grpcAddr(addr string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(addr, "http://"), "https://")
 }
 
 // 用来启动一个 API 服务（端口 9999），与用户进行交互，用户感知。
-func startAPIServer(apiAddr string, gee *geecache.Group) {
+func startAPIServer(apiAddr string, gee *geecache.Group, timeout time.Duration) {
 	http.Handle("/api", http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
+			//用户断开连接或者超时后不再等待慢查询
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
 			key := r.URL.Query().Get("key")
-			view, err := gee.Get(key)
+			view, err := gee.GetContext(ctx, key)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				http.Error(w, err.Error(), geecache.HTTPStatus(err))
 				return
 			}
 			w.Header().Set("Content-Type", "application/octet-stream")
@@ -2,9 +2,9 @@
 trap "rm server;kill 0" EXIT
 
 go build -o server
-./server -port=8001 &
-./server -port=8002 &
-./server -port=8003 -api=1 &
+./server -port=8001 -transport=${TRANSPORT:-http} &
+./server -port=8002 -transport=${TRANSPORT:-http} &
+./server -port=8003 -transport=${TRANSPORT:-http} -api=1 &
 
 sleep 2
 echo ">>> start test"